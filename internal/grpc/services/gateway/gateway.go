@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"net/url"
 	"strings"
+	"text/template"
 	"time"
 
 	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
@@ -29,6 +30,7 @@ import (
 	"github.com/ReneKroon/ttlcache/v2"
 	"github.com/cs3org/reva/pkg/errtypes"
 	"github.com/cs3org/reva/pkg/rgrpc"
+	"github.com/cs3org/reva/pkg/share"
 	"github.com/cs3org/reva/pkg/sharedconf"
 	"github.com/cs3org/reva/pkg/token"
 	"github.com/cs3org/reva/pkg/token/manager/registry"
@@ -65,6 +67,7 @@ type config struct {
 	TokenManager                  string `mapstructure:"token_manager"`
 	// ShareFolder is the location where to create shares in the recipient's storage provider.
 	ShareFolder         string                            `mapstructure:"share_folder"`
+	ShareJailTemplate   string                            `mapstructure:"share_jail_template"`
 	DataTransfersFolder string                            `mapstructure:"data_transfers_folder"`
 	HomeMapping         string                            `mapstructure:"home_mapping"`
 	TokenManagers       map[string]map[string]interface{} `mapstructure:"token_managers"`
@@ -116,10 +119,11 @@ func (c *config) init() {
 }
 
 type svc struct {
-	c              *config
-	dataGatewayURL url.URL
-	tokenmgr       token.Manager
-	etagCache      *ttlcache.Cache `mapstructure:"etag_cache"`
+	c                 *config
+	dataGatewayURL    url.URL
+	tokenmgr          token.Manager
+	etagCache         *ttlcache.Cache `mapstructure:"etag_cache"`
+	shareJailTemplate *template.Template
 }
 
 // New creates a new gateway svc that acts as a proxy for any grpc operation.
@@ -144,15 +148,21 @@ func New(m map[string]interface{}, ss *grpc.Server) (rgrpc.Service, error) {
 		return nil, err
 	}
 
+	shareJailTemplate, err := share.ParseJailTemplate(sharedconf.GetShareJailTemplate(c.ShareJailTemplate))
+	if err != nil {
+		return nil, err
+	}
+
 	etagCache := ttlcache.NewCache()
 	_ = etagCache.SetTTL(time.Duration(c.EtagCacheTTL) * time.Second)
 	etagCache.SkipTTLExtensionOnHit(true)
 
 	s := &svc{
-		c:              c,
-		dataGatewayURL: *u,
-		tokenmgr:       tokenManager,
-		etagCache:      etagCache,
+		c:                 c,
+		dataGatewayURL:    *u,
+		tokenmgr:          tokenManager,
+		etagCache:         etagCache,
+		shareJailTemplate: shareJailTemplate,
 	}
 
 	return s, nil
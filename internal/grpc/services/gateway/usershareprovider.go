@@ -30,6 +30,7 @@ import (
 	"github.com/cs3org/reva/pkg/errtypes"
 	"github.com/cs3org/reva/pkg/rgrpc/status"
 	"github.com/cs3org/reva/pkg/rgrpc/todo/pool"
+	"github.com/cs3org/reva/pkg/share"
 	"github.com/pkg/errors"
 )
 
@@ -300,7 +301,7 @@ func (s *svc) UpdateReceivedShare(ctx context.Context, req *collaboration.Update
 			if share == nil {
 				panic("gateway: error updating a received share: the share is nil")
 			}
-			createRefStatus := s.createReference(ctx, share.Share.ResourceId)
+			createRefStatus := s.createReference(ctx, share.Share)
 			rsp := &collaboration.UpdateReceivedShareResponse{Status: createRefStatus}
 
 			if createRefStatus.Code == rpc.Code_CODE_OK {
@@ -320,9 +321,10 @@ func (s *svc) UpdateReceivedShare(ctx context.Context, req *collaboration.Update
 	}, nil
 }
 
-func (s *svc) createReference(ctx context.Context, resourceID *provider.ResourceId) *rpc.Status {
+func (s *svc) createReference(ctx context.Context, sh *collaboration.Share) *rpc.Status {
 
 	log := appctx.GetLogger(ctx)
+	resourceID := sh.ResourceId
 
 	// get the metadata about the share
 	c, err := s.findByID(ctx, resourceID)
@@ -367,8 +369,13 @@ func (s *svc) createReference(ctx context.Context, resourceID *provider.Resource
 	// CreateReference(dropbox://x/y/z)
 	// It is the responsibility of the gateway to resolve these references and merge the response back
 	// from the main request.
-	// TODO(labkode): the name of the share should be the filename it points to by default.
-	refPath := path.Join(homeRes.Path, s.c.ShareFolder, path.Base(statRes.Info.Path))
+	// The name is built with the share jail template shared with the OCS API, so that the
+	// reported path of an accepted share matches the mount point.
+	name, err := share.MountName(s.shareJailTemplate, sh, statRes.Info)
+	if err != nil {
+		return status.NewInternal(ctx, err, "error building the share mount name")
+	}
+	refPath := path.Join(homeRes.Path, s.c.ShareFolder, name)
 	log.Info().Msg("mount path will be:" + refPath)
 
 	createRefReq := &provider.CreateReferenceRequest{
@@ -31,6 +31,7 @@ import (
 	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
 	// link "github.com/cs3org/go-cs3apis/cs3/sharing/link/v1beta1"
 	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	typespb "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
 	"github.com/cs3org/reva/pkg/appctx"
 	"github.com/cs3org/reva/pkg/errtypes"
 	"github.com/cs3org/reva/pkg/mime"
@@ -99,6 +100,10 @@ type service struct {
 	tmpFolder          string
 	dataServerURL      *url.URL
 	availableXS        []*provider.ResourceChecksumPriority
+	// checkXS rejects uploads with checksum algorithms missing from availableXS. It is only set
+	// when available_checksums is configured, the defaults do not list every algorithm the drivers
+	// compute, eg. the sha1 sent by ownCloud clients.
+	checkXS bool
 }
 
 func (s *service) Close() error {
@@ -144,6 +149,7 @@ func New(m map[string]interface{}, ss *grpc.Server) (rgrpc.Service, error) {
 		return nil, err
 	}
 
+	checkXS := len(c.AvailableXS) > 0
 	c.init()
 
 	if err := os.MkdirAll(c.TmpFolder, 0755); err != nil {
@@ -184,6 +190,7 @@ func New(m map[string]interface{}, ss *grpc.Server) (rgrpc.Service, error) {
 		mountID:       mountID,
 		dataServerURL: u,
 		availableXS:   xsTypes,
+		checkXS:       checkXS,
 	}
 
 	return service, nil
@@ -318,11 +325,26 @@ func (s *service) InitiateFileUpload(ctx context.Context, req *provider.Initiate
 		// TUS forward Upload-Checksum header as checksum, uses '[type] [hash]' format
 		if req.Opaque.Map["Upload-Checksum"] != nil {
 			metadata["checksum"] = string(req.Opaque.Map["Upload-Checksum"].Value)
+			// reject algorithms the storage cannot verify before an upload session is created
+			algorithm := strings.SplitN(metadata["checksum"], " ", 2)[0]
+			if s.checkXS && !s.isChecksumAvailable(algorithm) {
+				return &provider.InitiateFileUploadResponse{
+					Status: status.NewInvalidArg(ctx, fmt.Sprintf("The checksum algorithm %s is not supported.", strings.ToUpper(algorithm))),
+				}, nil
+			}
 		}
 		// ownCloud mtime to set for the uploaded file
 		if req.Opaque.Map["X-OC-Mtime"] != nil {
 			metadata["mtime"] = string(req.Opaque.Map["X-OC-Mtime"].Value)
 		}
+		// ownCloud chunking v1 size of the assembled file
+		if req.Opaque.Map["OC-Total-Length"] != nil {
+			metadata["total_length"] = string(req.Opaque.Map["OC-Total-Length"].Value)
+		}
+		// arbitrary metadata to persist for the uploaded file, json encoded map of keys to values
+		if e := req.Opaque.Map["Upload-Metadata"]; e != nil && e.Decoder == "json" {
+			metadata["arbitrary_metadata"] = string(e.Value)
+		}
 	}
 	uploadIDs, err := s.storage.InitiateUpload(ctx, newRef, uploadLength, metadata)
 	if err != nil {
@@ -378,6 +400,17 @@ func (s *service) InitiateFileUpload(ctx context.Context, req *provider.Initiate
 	return res, nil
 }
 
+// isChecksumAvailable checks if the storage supports the checksum algorithm
+func (s *service) isChecksumAvailable(algorithm string) bool {
+	t := PKG2GRPCXS(strings.ToLower(algorithm))
+	for _, xs := range s.availableXS {
+		if xs.Type == t {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *service) GetPath(ctx context.Context, req *provider.GetPathRequest) (*provider.GetPathResponse, error) {
 	// TODO(labkode): check that the storage ID is the same as the storage provider id.
 	fn, err := s.storage.GetPathByID(ctx, req.ResourceId)
@@ -698,7 +731,14 @@ func (s *service) ListFileVersions(ctx context.Context, req *provider.ListFileVe
 		}, nil
 	}
 
-	revs, err := s.storage.ListRevisions(ctx, newRef)
+	var revs []*provider.FileVersion
+	var next string
+	limit, token := revisionPage(req.Opaque)
+	if pager, ok := s.storage.(revisionPager); ok && (limit > 0 || token != "") {
+		revs, next, err = pager.ListRevisionsPage(ctx, newRef, limit, token)
+	} else {
+		revs, err = s.storage.ListRevisions(ctx, newRef)
+	}
 	if err != nil {
 		var st *rpc.Status
 		switch err.(type) {
@@ -706,6 +746,8 @@ func (s *service) ListFileVersions(ctx context.Context, req *provider.ListFileVe
 			st = status.NewNotFound(ctx, "path not found when listing file versions")
 		case errtypes.PermissionDenied:
 			st = status.NewPermissionDenied(ctx, err, "permission denied")
+		case errtypes.IsBadRequest:
+			st = status.NewInvalidArg(ctx, err.Error())
 		default:
 			st = status.NewInternal(ctx, err, "error listing file versions: "+req.Ref.String())
 		}
@@ -718,9 +760,34 @@ func (s *service) ListFileVersions(ctx context.Context, req *provider.ListFileVe
 		Status:   status.NewOK(ctx),
 		Versions: revs,
 	}
+	if next != "" {
+		res.Opaque = &typespb.Opaque{
+			Map: map[string]*typespb.OpaqueEntry{
+				"token": {Decoder: "plain", Value: []byte(next)},
+			},
+		}
+	}
 	return res, nil
 }
 
+// revisionPager is implemented by storages that can list the revisions of a file in pages, newest first
+type revisionPager interface {
+	ListRevisionsPage(ctx context.Context, ref *provider.Reference, limit int, token string) ([]*provider.FileVersion, string, error)
+}
+
+// revisionPage reads the requested page size and continuation token of a ListFileVersions request
+func revisionPage(o *typespb.Opaque) (int, string) {
+	var limit int
+	var token string
+	if e := o.GetMap()["limit"]; e != nil && e.Decoder == "plain" {
+		limit, _ = strconv.Atoi(string(e.Value))
+	}
+	if e := o.GetMap()["token"]; e != nil && e.Decoder == "plain" {
+		token = string(e.Value)
+	}
+	return limit, token
+}
+
 func (s *service) RestoreFileVersion(ctx context.Context, req *provider.RestoreFileVersionRequest) (*provider.RestoreFileVersionResponse, error) {
 	newRef, err := s.unwrap(ctx, req.Ref)
 	if err != nil {
@@ -0,0 +1,53 @@
+// Copyright 2018-2021 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package storageprovider
+
+import (
+	"context"
+	"testing"
+
+	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	typespb "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
+)
+
+func TestInitiateFileUploadRejectsUnsupportedChecksum(t *testing.T) {
+	xsTypes, err := parseXSTypes(map[string]uint32{"sha1": 100})
+	if err != nil {
+		t.Fatalf("error parsing checksum types: %v", err)
+	}
+	// no storage is configured, the request must be rejected before an upload is initiated
+	s := &service{mountPath: "/", availableXS: xsTypes, checkXS: true}
+
+	res, err := s.InitiateFileUpload(context.Background(), &provider.InitiateFileUploadRequest{
+		Ref: &provider.Reference{Spec: &provider.Reference_Path{Path: "/file.txt"}},
+		Opaque: &typespb.Opaque{Map: map[string]*typespb.OpaqueEntry{
+			"Upload-Checksum": {Decoder: "plain", Value: []byte("md5 5d41402abc4b2a76b9719d911017c592")},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("InitiateFileUpload failed: %v", err)
+	}
+	if res.Status.Code != rpc.Code_CODE_INVALID_ARGUMENT {
+		t.Fatalf("InitiateFileUpload returned %s instead of expected %s", res.Status.Code, rpc.Code_CODE_INVALID_ARGUMENT)
+	}
+	if res.Status.Message != "The checksum algorithm MD5 is not supported." {
+		t.Errorf("unexpected message %q", res.Status.Message)
+	}
+}
@@ -20,17 +20,27 @@ package usershareprovider
 
 import (
 	"context"
+	"encoding/json"
+	"time"
 
 	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
 	collaboration "github.com/cs3org/go-cs3apis/cs3/sharing/collaboration/v1beta1"
 	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	typespb "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
 	"github.com/cs3org/reva/pkg/appctx"
+	"github.com/cs3org/reva/pkg/conversions"
 	"github.com/cs3org/reva/pkg/errtypes"
+	"github.com/cs3org/reva/pkg/events"
 	"github.com/cs3org/reva/pkg/rgrpc"
 	"github.com/cs3org/reva/pkg/rgrpc/status"
+	"github.com/cs3org/reva/pkg/rgrpc/todo/pool"
 	"github.com/cs3org/reva/pkg/share"
 	"github.com/cs3org/reva/pkg/share/manager/registry"
+	"github.com/cs3org/reva/pkg/share/token"
+	"github.com/cs3org/reva/pkg/sharedconf"
 	"github.com/cs3org/reva/pkg/user"
+	"github.com/cs3org/reva/pkg/utils"
 	"github.com/mitchellh/mapstructure"
 	"github.com/pkg/errors"
 	"google.golang.org/grpc"
@@ -40,20 +50,92 @@ func init() {
 	rgrpc.Register("usershareprovider", New)
 }
 
+// permissionsTemplateOpaqueKey is the opaque key clients can use to request
+// a permissions template instead of sending the full permission set
+const permissionsTemplateOpaqueKey = "permissions_template"
+
+// shareTokenOpaqueKey is the opaque key used to return the token minted for a new share
+// and to resolve a token to its share with GetShare
+const shareTokenOpaqueKey = "share_token"
+
+// resourceIDOpaqueKey is the opaque key clients can use to only list the received shares of a resource
+const resourceIDOpaqueKey = "resource_id"
+
+const defaultShareTokenExpiration int64 = 86400 // 1 day
+
 type config struct {
-	Driver  string                            `mapstructure:"driver"`
-	Drivers map[string]map[string]interface{} `mapstructure:"drivers"`
+	Driver      string                            `mapstructure:"driver"`
+	Drivers     map[string]map[string]interface{} `mapstructure:"drivers"`
+	GatewayAddr string                            `mapstructure:"gateway_addr"`
+	// PermissionTemplates maps template names to the role whose permissions are granted
+	// when a template is requested, eg. "editor" = "editor"
+	PermissionTemplates map[string]string `mapstructure:"permission_templates"`
+	// ShareTokens enables minting a token for every new share that can be resolved
+	// to the share and its grantee with GetShare
+	ShareTokens bool `mapstructure:"share_tokens"`
+	// ShareTokenSecret signs the share tokens. It must differ from the secret used for user tokens
+	ShareTokenSecret string `mapstructure:"share_token_secret"`
+	// ShareTokenExpiration is the number of seconds a share token stays valid
+	ShareTokenExpiration int64 `mapstructure:"share_token_expiration"`
+	// AllowResharing allows creating shares with permissions to manage grants,
+	// as long as the sharer is allowed to add grants to the resource. Defaults to true.
+	AllowResharing bool `mapstructure:"allow_resharing"`
+	// StateChangeWebhook is called with an event whenever a recipient accepts or rejects a share
+	StateChangeWebhook string `mapstructure:"state_change_webhook"`
+	// StateChangeWebhookTimeout is the number of seconds to wait for the webhook
+	StateChangeWebhookTimeout int `mapstructure:"state_change_webhook_timeout"`
 }
 
 func (c *config) init() {
 	if c.Driver == "" {
 		c.Driver = "json"
 	}
+	c.GatewayAddr = sharedconf.GetGatewaySVC(c.GatewayAddr)
+	if c.PermissionTemplates == nil {
+		c.PermissionTemplates = map[string]string{
+			conversions.RoleViewer:   conversions.RoleViewer,
+			conversions.RoleEditor:   conversions.RoleEditor,
+			conversions.RoleUploader: conversions.RoleUploader,
+		}
+	}
+	if c.StateChangeWebhookTimeout == 0 {
+		c.StateChangeWebhookTimeout = 10
+	}
+	if c.ShareTokenExpiration == 0 {
+		c.ShareTokenExpiration = defaultShareTokenExpiration
+	}
+}
+
+func (c *config) validate() error {
+	for name, role := range c.PermissionTemplates {
+		if conversions.RoleFromName(role).Name == conversions.RoleUnknown {
+			return errors.Errorf("permissions template %s uses unknown role %s", name, role)
+		}
+	}
+	if c.ShareTokens {
+		if c.ShareTokenSecret == "" {
+			return errors.New("share_token_secret is required to mint share tokens")
+		}
+		if c.ShareTokenSecret == sharedconf.GetJWTSecret("") {
+			return errors.New("share_token_secret must differ from the jwt secret")
+		}
+	}
+	return nil
 }
 
 type service struct {
-	conf *config
-	sm   share.Manager
+	conf      *config
+	sm        share.Manager
+	tokens    *token.Manager
+	publisher events.Publisher
+}
+
+// shareStateChangedEvent notifies the sharer about a recipient accepting or rejecting a share
+type shareStateChangedEvent struct {
+	ShareID   string         `json:"share_id"`
+	State     string         `json:"state"`
+	Recipient *userpb.UserId `json:"recipient"`
+	Sharer    *userpb.UserId `json:"sharer"`
 }
 
 func getShareManager(c *config) (share.Manager, error) {
@@ -77,7 +159,8 @@ func (s *service) Register(ss *grpc.Server) {
 }
 
 func parseConfig(m map[string]interface{}) (*config, error) {
-	c := &config{}
+	// resharing is allowed unless it is disabled explicitly
+	c := &config{AllowResharing: true}
 	if err := mapstructure.Decode(m, c); err != nil {
 		err = errors.Wrap(err, "error decoding conf")
 		return nil, err
@@ -94,6 +177,9 @@ func New(m map[string]interface{}, ss *grpc.Server) (rgrpc.Service, error) {
 	}
 
 	c.init()
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
 
 	sm, err := getShareManager(c)
 	if err != nil {
@@ -105,6 +191,19 @@ func New(m map[string]interface{}, ss *grpc.Server) (rgrpc.Service, error) {
 		sm:   sm,
 	}
 
+	if c.StateChangeWebhook != "" {
+		// do not keep the recipient waiting for the webhook
+		service.publisher = events.NewAsyncPublisher(
+			events.NewWebhookPublisher(c.StateChangeWebhook, time.Duration(c.StateChangeWebhookTimeout)*time.Second),
+		)
+	}
+
+	if c.ShareTokens {
+		if service.tokens, err = token.New(c.ShareTokenSecret, time.Duration(c.ShareTokenExpiration)*time.Second); err != nil {
+			return nil, err
+		}
+	}
+
 	return service, nil
 }
 
@@ -115,6 +214,18 @@ func (s *service) CreateShare(ctx context.Context, req *collaboration.CreateShar
 		g := &userpb.UserId{OpaqueId: req.Grant.Grantee.GetUserId().OpaqueId, Idp: u.Id.Idp}
 		req.Grant.Grantee.Id = &provider.Grantee_UserId{UserId: g}
 	}
+	permissions, err := s.expandPermissionsTemplate(req.Opaque)
+	if err != nil {
+		return &collaboration.CreateShareResponse{
+			Status: status.NewInvalidArg(ctx, err.Error()),
+		}, nil
+	}
+	if permissions != nil {
+		req.Grant.Permissions = permissions
+	}
+	if st := s.checkResharing(ctx, req.Grant.GetPermissions().GetPermissions(), req.ResourceInfo.GetPermissionSet()); st != nil {
+		return &collaboration.CreateShareResponse{Status: st}, nil
+	}
 	share, err := s.sm.Share(ctx, req.ResourceInfo, req.Grant)
 	if err != nil {
 		return &collaboration.CreateShareResponse{
@@ -126,9 +237,122 @@ func (s *service) CreateShare(ctx context.Context, req *collaboration.CreateShar
 		Status: status.NewOK(ctx),
 		Share:  share,
 	}
+
+	if s.tokens != nil {
+		tkn, err := s.tokens.MintToken(share)
+		if err != nil {
+			// do not keep a share the client has not been told about
+			ref := &collaboration.ShareReference{Spec: &collaboration.ShareReference_Id{Id: share.GetId()}}
+			if rerr := s.sm.Unshare(ctx, ref); rerr != nil {
+				appctx.GetLogger(ctx).Error().Err(rerr).Str("share", share.GetId().GetOpaqueId()).Msg("could not remove share after minting its token failed")
+			}
+			return &collaboration.CreateShareResponse{
+				Status: status.NewInternal(ctx, err, "error minting share token"),
+			}, nil
+		}
+		res.Opaque = &typespb.Opaque{
+			Map: map[string]*typespb.OpaqueEntry{
+				shareTokenOpaqueKey: {Decoder: "plain", Value: []byte(tkn)},
+			},
+		}
+	}
 	return res, nil
 }
 
+// expandPermissionsTemplate returns the permissions of the template requested in the opaque.
+// It returns nil if no template has been requested.
+func (s *service) expandPermissionsTemplate(o *typespb.Opaque) (*collaboration.SharePermissions, error) {
+	if o == nil || o.Map[permissionsTemplateOpaqueKey] == nil {
+		return nil, nil
+	}
+	name := string(o.Map[permissionsTemplateOpaqueKey].Value)
+	role, ok := s.conf.PermissionTemplates[name]
+	if !ok {
+		return nil, errtypes.BadRequest("unknown permissions template: " + name)
+	}
+	return &collaboration.SharePermissions{
+		Permissions: conversions.RoleFromName(role).CS3ResourcePermissions(),
+	}, nil
+}
+
+// checkResharing returns an error status if the requested share permissions allow managing
+// grants but resharing is disabled or the sharer may not add grants to the resource.
+func (s *service) checkResharing(ctx context.Context, requested, available *provider.ResourcePermissions) *rpc.Status {
+	if !managesGrants(requested) {
+		return nil
+	}
+	if !s.conf.AllowResharing {
+		logShareDenied(ctx, requested, available, "resharing not supported")
+		return status.NewInvalidArg(ctx, "resharing not supported")
+	}
+	if !available.GetAddGrant() {
+		logShareDenied(ctx, requested, available, "no permission to reshare")
+		return status.NewPermissionDenied(ctx, nil, "no permission to reshare")
+	}
+	return nil
+}
+
+// sharerPermissions returns the permissions of the current user on the resource of a share.
+// An UpdateShareRequest has no resource info of its own, so the resource is stat'ed on behalf
+// of the user.
+func (s *service) sharerPermissions(ctx context.Context, ref *collaboration.ShareReference) (*provider.ResourcePermissions, *rpc.Status) {
+	share, err := s.sm.GetShare(ctx, ref)
+	if err != nil {
+		if _, ok := err.(errtypes.IsNotFound); ok {
+			return nil, status.NewNotFound(ctx, "share not found")
+		}
+		return nil, status.NewInternal(ctx, err, "error getting share")
+	}
+
+	client, err := pool.GetGatewayServiceClient(s.conf.GatewayAddr)
+	if err != nil {
+		return nil, status.NewInternal(ctx, err, "error getting gateway client")
+	}
+	res, err := client.Stat(ctx, &provider.StatRequest{
+		Ref: &provider.Reference{Spec: &provider.Reference_Id{Id: share.ResourceId}},
+	})
+	switch {
+	case err != nil:
+		return nil, status.NewInternal(ctx, err, "error stating shared resource")
+	case res.Status.Code == rpc.Code_CODE_NOT_FOUND:
+		// the sharer can not see the resource anymore
+		return nil, nil
+	case res.Status.Code != rpc.Code_CODE_OK:
+		return nil, status.NewInternal(ctx, errtypes.InternalError(res.Status.Message), "error stating shared resource")
+	}
+	return res.Info.PermissionSet, nil
+}
+
+// receivedSharesFilters returns the filters for listing received shares. The CS3 request has no
+// filters, so clients pass the resource id in the opaque.
+func receivedSharesFilters(o *typespb.Opaque) ([]*collaboration.ListSharesRequest_Filter, error) {
+	if o == nil || o.Map[resourceIDOpaqueKey] == nil {
+		return nil, nil
+	}
+	id := &provider.ResourceId{}
+	if err := json.Unmarshal(o.Map[resourceIDOpaqueKey].Value, id); err != nil {
+		return nil, errtypes.BadRequest("invalid resource id: " + err.Error())
+	}
+	return []*collaboration.ListSharesRequest_Filter{{
+		Type: collaboration.ListSharesRequest_Filter_TYPE_RESOURCE_ID,
+		Term: &collaboration.ListSharesRequest_Filter_ResourceId{ResourceId: id},
+	}}, nil
+}
+
+// managesGrants checks if the permissions allow managing grants, which is needed for resharing
+func managesGrants(p *provider.ResourcePermissions) bool {
+	return p.GetAddGrant() || p.GetUpdateGrant() || p.GetRemoveGrant()
+}
+
+// logShareDenied logs the requested and the available permissions when a share is denied
+func logShareDenied(ctx context.Context, requested, available *provider.ResourcePermissions, decision string) {
+	appctx.GetLogger(ctx).Debug().
+		Interface("requested_permissions", requested).
+		Interface("resource_permissions", available).
+		Str("decision", decision).
+		Msg("share denied")
+}
+
 func (s *service) RemoveShare(ctx context.Context, req *collaboration.RemoveShareRequest) (*collaboration.RemoveShareResponse, error) {
 	err := s.sm.Unshare(ctx, req.Ref)
 	if err != nil {
@@ -143,6 +367,10 @@ func (s *service) RemoveShare(ctx context.Context, req *collaboration.RemoveShar
 }
 
 func (s *service) GetShare(ctx context.Context, req *collaboration.GetShareRequest) (*collaboration.GetShareResponse, error) {
+	if req.Opaque != nil && req.Opaque.Map[shareTokenOpaqueKey] != nil {
+		return s.resolveShareToken(ctx, string(req.Opaque.Map[shareTokenOpaqueKey].Value))
+	}
+
 	share, err := s.sm.GetShare(ctx, req.Ref)
 	if err != nil {
 		return &collaboration.GetShareResponse{
@@ -156,6 +384,50 @@ func (s *service) GetShare(ctx context.Context, req *collaboration.GetShareReque
 	}, nil
 }
 
+// resolveShareToken returns the share a token has been minted for. GetShare is not an
+// unprotected endpoint, so the caller still needs to be authenticated, but it does not need
+// to be the owner or the grantee of the share: the share is looked up on behalf of its owner.
+// Tokens of removed shares or shares that changed their grantee are rejected.
+func (s *service) resolveShareToken(ctx context.Context, tkn string) (*collaboration.GetShareResponse, error) {
+	if s.tokens == nil {
+		return &collaboration.GetShareResponse{
+			Status: status.NewUnimplemented(ctx, nil, "share tokens are disabled"),
+		}, nil
+	}
+
+	claimed, err := s.tokens.ResolveToken(tkn)
+	if err != nil {
+		return &collaboration.GetShareResponse{
+			Status: status.NewPermissionDenied(ctx, err, "invalid share token"),
+		}, nil
+	}
+
+	ownerCtx := user.ContextSetUser(ctx, &userpb.User{Id: claimed.Owner})
+	share, err := s.sm.GetShare(ownerCtx, &collaboration.ShareReference{
+		Spec: &collaboration.ShareReference_Id{Id: claimed.Id},
+	})
+	switch {
+	case err != nil:
+		if _, ok := err.(errtypes.IsNotFound); ok {
+			return &collaboration.GetShareResponse{
+				Status: status.NewPermissionDenied(ctx, err, "share token has been revoked"),
+			}, nil
+		}
+		return &collaboration.GetShareResponse{
+			Status: status.NewInternal(ctx, err, "error getting share"),
+		}, nil
+	case !utils.GranteeEqual(share.Grantee, claimed.Grantee):
+		return &collaboration.GetShareResponse{
+			Status: status.NewPermissionDenied(ctx, nil, "share token has been revoked"),
+		}, nil
+	}
+
+	return &collaboration.GetShareResponse{
+		Status: status.NewOK(ctx),
+		Share:  share,
+	}, nil
+}
+
 func (s *service) ListShares(ctx context.Context, req *collaboration.ListSharesRequest) (*collaboration.ListSharesResponse, error) {
 	shares, err := s.sm.ListShares(ctx, req.Filters) // TODO(labkode): add filter to share manager
 	if err != nil {
@@ -172,7 +444,26 @@ func (s *service) ListShares(ctx context.Context, req *collaboration.ListSharesR
 }
 
 func (s *service) UpdateShare(ctx context.Context, req *collaboration.UpdateShareRequest) (*collaboration.UpdateShareResponse, error) {
-	share, err := s.sm.UpdateShare(ctx, req.Ref, req.Field.GetPermissions()) // TODO(labkode): check what to update
+	permissions, err := s.expandPermissionsTemplate(req.Opaque)
+	if err != nil {
+		return &collaboration.UpdateShareResponse{
+			Status: status.NewInvalidArg(ctx, err.Error()),
+		}, nil
+	}
+	if permissions == nil {
+		permissions = req.Field.GetPermissions()
+	}
+	var available *provider.ResourcePermissions
+	if managesGrants(permissions.GetPermissions()) && s.conf.AllowResharing {
+		var st *rpc.Status
+		if available, st = s.sharerPermissions(ctx, req.Ref); st != nil {
+			return &collaboration.UpdateShareResponse{Status: st}, nil
+		}
+	}
+	if st := s.checkResharing(ctx, permissions.GetPermissions(), available); st != nil {
+		return &collaboration.UpdateShareResponse{Status: st}, nil
+	}
+	share, err := s.sm.UpdateShare(ctx, req.Ref, permissions) // TODO(labkode): check what to update
 	if err != nil {
 		return &collaboration.UpdateShareResponse{
 			Status: status.NewInternal(ctx, err, "error updating share"),
@@ -187,7 +478,14 @@ func (s *service) UpdateShare(ctx context.Context, req *collaboration.UpdateShar
 }
 
 func (s *service) ListReceivedShares(ctx context.Context, req *collaboration.ListReceivedSharesRequest) (*collaboration.ListReceivedSharesResponse, error) {
-	shares, err := s.sm.ListReceivedShares(ctx) // TODO(labkode): check what to update
+	filters, err := receivedSharesFilters(req.Opaque)
+	if err != nil {
+		return &collaboration.ListReceivedSharesResponse{
+			Status: status.NewInvalidArg(ctx, err.Error()),
+		}, nil
+	}
+
+	shares, err := s.sm.ListReceivedShares(ctx, filters) // TODO(labkode): check what to update
 	if err != nil {
 		return &collaboration.ListReceivedSharesResponse{
 			Status: status.NewInternal(ctx, err, "error listing received shares"),
@@ -220,6 +518,13 @@ func (s *service) GetReceivedShare(ctx context.Context, req *collaboration.GetRe
 }
 
 func (s *service) UpdateReceivedShare(ctx context.Context, req *collaboration.UpdateReceivedShareRequest) (*collaboration.UpdateReceivedShareResponse, error) {
+	var previousState collaboration.ShareState
+	if s.publisher != nil {
+		if rs, err := s.sm.GetReceivedShare(ctx, req.Ref); err == nil {
+			previousState = rs.State
+		}
+	}
+
 	share, err := s.sm.UpdateReceivedShare(ctx, req.Ref, req.Field) // TODO(labkode): check what to update
 	if err != nil {
 		return &collaboration.UpdateReceivedShareResponse{
@@ -227,9 +532,33 @@ func (s *service) UpdateReceivedShare(ctx context.Context, req *collaboration.Up
 		}, nil
 	}
 
+	if s.publisher != nil && req.Field.GetState() != previousState {
+		s.publishStateChange(ctx, share.Share, req.Field.GetState())
+	}
+
 	res := &collaboration.UpdateReceivedShareResponse{
 		Status: status.NewOK(ctx),
 		Share:  share,
 	}
 	return res, nil
 }
+
+// publishStateChange notifies the sharer about the new state of a received share.
+// Notifications are best effort and published in the background.
+func (s *service) publishStateChange(ctx context.Context, share *collaboration.Share, state collaboration.ShareState) {
+	switch state {
+	case collaboration.ShareState_SHARE_STATE_ACCEPTED, collaboration.ShareState_SHARE_STATE_REJECTED:
+	default:
+		return
+	}
+	u := user.ContextMustGetUser(ctx)
+	ev := &shareStateChangedEvent{
+		ShareID:   share.GetId().GetOpaqueId(),
+		State:     state.String(),
+		Recipient: u.Id,
+		Sharer:    share.GetCreator(),
+	}
+	if err := s.publisher.Publish(ctx, "share_state_changed", ev); err != nil {
+		appctx.GetLogger(ctx).Warn().Err(err).Str("share", ev.ShareID).Msg("could not publish share state change")
+	}
+}
@@ -0,0 +1,432 @@
+// Copyright 2018-2021 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package usershareprovider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	collaboration "github.com/cs3org/go-cs3apis/cs3/sharing/collaboration/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	typespb "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
+	"github.com/cs3org/reva/pkg/appctx"
+	"github.com/cs3org/reva/pkg/conversions"
+	"github.com/cs3org/reva/pkg/events"
+	"github.com/cs3org/reva/pkg/rgrpc/status"
+	_ "github.com/cs3org/reva/pkg/share/manager/memory"
+	"github.com/cs3org/reva/pkg/user"
+	"github.com/cs3org/reva/pkg/utils"
+	"github.com/golang/protobuf/proto"
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc"
+)
+
+var (
+	einstein = &userpb.User{
+		Id:       &userpb.UserId{Idp: "http://localhost:9998", OpaqueId: "4c510ada-c86b-4815-8820-42cdf82c3d51"},
+		Username: "einstein",
+	}
+	marie = &userpb.User{
+		Id:       &userpb.UserId{Idp: "http://localhost:9998", OpaqueId: "f7fbf8c8-139b-4376-b307-cf0a8c2d0d9c"},
+		Username: "marie",
+	}
+)
+
+func newTestService(t *testing.T, m map[string]interface{}) *service {
+	if m == nil {
+		m = map[string]interface{}{}
+	}
+	m["driver"] = "memory"
+	svc, err := New(m, nil)
+	if err != nil {
+		t.Fatalf("error creating usershareprovider: %v", err)
+	}
+	return svc.(*service)
+}
+
+func newCreateShareRequest(path string, opaque *typespb.Opaque) *collaboration.CreateShareRequest {
+	return &collaboration.CreateShareRequest{
+		Opaque: opaque,
+		ResourceInfo: &provider.ResourceInfo{
+			Id:    &provider.ResourceId{StorageId: "storage", OpaqueId: path},
+			Path:  path,
+			Owner: einstein.Id,
+		},
+		Grant: &collaboration.ShareGrant{
+			Grantee: &provider.Grantee{
+				Type: provider.GranteeType_GRANTEE_TYPE_USER,
+				Id:   &provider.Grantee_UserId{UserId: marie.Id},
+			},
+			Permissions: &collaboration.SharePermissions{
+				Permissions: conversions.NewViewerRole().CS3ResourcePermissions(),
+			},
+		},
+	}
+}
+
+func templateOpaque(name string) *typespb.Opaque {
+	return &typespb.Opaque{
+		Map: map[string]*typespb.OpaqueEntry{
+			permissionsTemplateOpaqueKey: {Decoder: "plain", Value: []byte(name)},
+		},
+	}
+}
+
+func TestCreateShareWithPermissionsTemplate(t *testing.T) {
+	s := newTestService(t, nil)
+	ctx := user.ContextSetUser(context.Background(), einstein)
+
+	res, err := s.CreateShare(ctx, newCreateShareRequest("/editor", templateOpaque("editor")))
+	if err != nil {
+		t.Fatalf("error creating share: %v", err)
+	}
+	if res.Status.Code != rpc.Code_CODE_OK {
+		t.Fatalf("creating share returned status %s", res.Status.Code)
+	}
+	expected := conversions.NewEditorRole().CS3ResourcePermissions()
+	if !proto.Equal(res.Share.Permissions.Permissions, expected) {
+		t.Errorf("share permissions %v do not match the editor template %v", res.Share.Permissions.Permissions, expected)
+	}
+
+	res, err = s.CreateShare(ctx, newCreateShareRequest("/unknown", templateOpaque("unknown")))
+	if err != nil {
+		t.Fatalf("error creating share: %v", err)
+	}
+	if res.Status.Code != rpc.Code_CODE_INVALID_ARGUMENT {
+		t.Errorf("creating share with an unknown template returned status %s", res.Status.Code)
+	}
+}
+
+func TestCreateShareIssuesResolvableToken(t *testing.T) {
+	s := newTestService(t, map[string]interface{}{
+		"share_tokens":       true,
+		"share_token_secret": "secret",
+	})
+	ctx := user.ContextSetUser(context.Background(), einstein)
+
+	res, err := s.CreateShare(ctx, newCreateShareRequest("/tokenized", nil))
+	if err != nil || res.Status.Code != rpc.Code_CODE_OK {
+		t.Fatalf("error creating share: %v %v", err, res.GetStatus())
+	}
+	if res.Opaque == nil || res.Opaque.Map[shareTokenOpaqueKey] == nil {
+		t.Fatalf("no share token returned")
+	}
+
+	// the token is resolved by a caller that is neither the sharer nor the grantee
+	other := user.ContextSetUser(context.Background(), &userpb.User{Id: &userpb.UserId{OpaqueId: "service"}})
+	resolve := &collaboration.GetShareRequest{Opaque: res.Opaque}
+	gsRes, err := s.GetShare(other, resolve)
+	if err != nil || gsRes.Status.Code != rpc.Code_CODE_OK {
+		t.Fatalf("error resolving share token: %v %v", err, gsRes.GetStatus())
+	}
+	if gsRes.Share.Id.OpaqueId != res.Share.Id.OpaqueId {
+		t.Errorf("share token resolved to share %s instead of %s", gsRes.Share.Id.OpaqueId, res.Share.Id.OpaqueId)
+	}
+	if !utils.UserEqual(gsRes.Share.Grantee.GetUserId(), marie.Id) {
+		t.Errorf("share token resolved to grantee %v instead of %v", gsRes.Share.Grantee, marie.Id)
+	}
+
+	rmRes, err := s.RemoveShare(ctx, &collaboration.RemoveShareRequest{
+		Ref: &collaboration.ShareReference{Spec: &collaboration.ShareReference_Id{Id: res.Share.Id}},
+	})
+	if err != nil || rmRes.Status.Code != rpc.Code_CODE_OK {
+		t.Fatalf("error removing share: %v %v", err, rmRes.GetStatus())
+	}
+	gsRes, err = s.GetShare(other, resolve)
+	if err != nil {
+		t.Fatalf("error resolving share token: %v", err)
+	}
+	if gsRes.Status.Code != rpc.Code_CODE_PERMISSION_DENIED {
+		t.Errorf("resolving the token of a removed share returned status %s", gsRes.Status.Code)
+	}
+}
+
+func TestCreateShareIsRemovedWhenTheTokenCannotBeMinted(t *testing.T) {
+	s := newTestService(t, map[string]interface{}{
+		"share_tokens":       true,
+		"share_token_secret": "secret",
+	})
+	ctx := user.ContextSetUser(context.Background(), einstein)
+
+	// tokens can't be minted for shares without an owner
+	req := newCreateShareRequest("/ownerless", nil)
+	req.ResourceInfo.Owner = nil
+	res, err := s.CreateShare(ctx, req)
+	if err != nil {
+		t.Fatalf("error creating share: %v", err)
+	}
+	if res.Status.Code != rpc.Code_CODE_INTERNAL {
+		t.Fatalf("creating a share without a token returned status %s", res.Status.Code)
+	}
+
+	lsRes, err := s.ListShares(ctx, &collaboration.ListSharesRequest{})
+	if err != nil || lsRes.Status.Code != rpc.Code_CODE_OK {
+		t.Fatalf("error listing shares: %v %v", err, lsRes.GetStatus())
+	}
+	for _, share := range lsRes.Shares {
+		if share.ResourceId.GetOpaqueId() == "/ownerless" {
+			t.Errorf("share %s was kept after minting its token failed", share.Id.OpaqueId)
+		}
+	}
+}
+
+func TestShareTokensRequireDedicatedSecret(t *testing.T) {
+	if _, err := New(map[string]interface{}{"driver": "memory", "share_tokens": true}, nil); err == nil {
+		t.Errorf("share tokens were enabled without a secret")
+	}
+}
+
+func TestCreateShareResharing(t *testing.T) {
+	coowner := conversions.NewCoownerRole().CS3ResourcePermissions()
+
+	tests := []struct {
+		name           string
+		allow          bool
+		sharerCanGrant bool
+		expected       rpc.Code
+	}{
+		{"disabled", false, true, rpc.Code_CODE_INVALID_ARGUMENT},
+		{"enabled", true, true, rpc.Code_CODE_OK},
+		{"enabled without AddGrant", true, false, rpc.Code_CODE_PERMISSION_DENIED},
+	}
+
+	for _, tt := range tests {
+		s := newTestService(t, map[string]interface{}{"allow_resharing": tt.allow})
+		ctx := user.ContextSetUser(context.Background(), einstein)
+
+		req := newCreateShareRequest("/reshare", nil)
+		req.Grant.Permissions.Permissions = coowner
+		req.ResourceInfo.PermissionSet = &provider.ResourcePermissions{Stat: true, AddGrant: tt.sharerCanGrant}
+
+		res, err := s.CreateShare(ctx, req)
+		if err != nil {
+			t.Fatalf("%s: error creating share: %v", tt.name, err)
+		}
+		if res.Status.Code != tt.expected {
+			t.Errorf("%s: creating a reshare returned status %s instead of %s", tt.name, res.Status.Code, tt.expected)
+		}
+	}
+}
+
+// storage stats every resource with the given permissions for the sharer, nil permissions
+// make the resource invisible to the sharer
+type storage struct {
+	gateway.UnimplementedGatewayAPIServer
+	permissions *provider.ResourcePermissions
+}
+
+func (s *storage) Stat(ctx context.Context, req *provider.StatRequest) (*provider.StatResponse, error) {
+	if s.permissions == nil {
+		return &provider.StatResponse{Status: status.NewNotFound(ctx, "not found")}, nil
+	}
+	return &provider.StatResponse{
+		Status: status.NewOK(ctx),
+		Info: &provider.ResourceInfo{
+			Id:            req.Ref.GetId(),
+			PermissionSet: s.permissions,
+		},
+	}, nil
+}
+
+// newGateway starts a gateway stating resources with the given permissions
+func newGateway(t *testing.T, permissions *provider.ResourcePermissions) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	srv := grpc.NewServer()
+	gateway.RegisterGatewayAPIServer(srv, &storage{permissions: permissions})
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+	return lis.Addr().String()
+}
+
+func TestUpdateShareResharing(t *testing.T) {
+	coowner := conversions.NewCoownerRole().CS3ResourcePermissions()
+
+	tests := []struct {
+		name     string
+		allow    bool
+		sharer   *provider.ResourcePermissions
+		expected rpc.Code
+	}{
+		{"disabled", false, &provider.ResourcePermissions{Stat: true, AddGrant: true}, rpc.Code_CODE_INVALID_ARGUMENT},
+		{"enabled", true, &provider.ResourcePermissions{Stat: true, AddGrant: true}, rpc.Code_CODE_OK},
+		{"enabled without AddGrant", true, &provider.ResourcePermissions{Stat: true}, rpc.Code_CODE_PERMISSION_DENIED},
+		{"enabled without access to the resource", true, nil, rpc.Code_CODE_PERMISSION_DENIED},
+	}
+
+	for _, tt := range tests {
+		s := newTestService(t, map[string]interface{}{
+			"allow_resharing": tt.allow,
+			"gateway_addr":    newGateway(t, tt.sharer),
+		})
+		ctx := user.ContextSetUser(context.Background(), einstein)
+
+		cRes, err := s.CreateShare(ctx, newCreateShareRequest("/reshare", nil))
+		if err != nil || cRes.Status.Code != rpc.Code_CODE_OK {
+			t.Fatalf("%s: error creating share: %v %v", tt.name, err, cRes.GetStatus())
+		}
+
+		// permissions claimed by the client are ignored
+		claimed, err := json.Marshal(&provider.ResourcePermissions{Stat: true, AddGrant: true})
+		if err != nil {
+			t.Fatalf("%s: error encoding permissions: %v", tt.name, err)
+		}
+		req := &collaboration.UpdateShareRequest{
+			Opaque: &typespb.Opaque{Map: map[string]*typespb.OpaqueEntry{
+				"resource_permissions": {Decoder: "json", Value: claimed},
+			}},
+			Ref: &collaboration.ShareReference{Spec: &collaboration.ShareReference_Id{Id: cRes.Share.Id}},
+			Field: &collaboration.UpdateShareRequest_UpdateField{
+				Field: &collaboration.UpdateShareRequest_UpdateField_Permissions{
+					Permissions: &collaboration.SharePermissions{Permissions: coowner},
+				},
+			},
+		}
+
+		res, err := s.UpdateShare(ctx, req)
+		if err != nil {
+			t.Fatalf("%s: error updating share: %v", tt.name, err)
+		}
+		if res.Status.Code != tt.expected {
+			t.Errorf("%s: updating a share to a reshare returned status %s instead of %s", tt.name, res.Status.Code, tt.expected)
+		}
+	}
+}
+
+func TestCreateShareLogsDenial(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := zerolog.New(buf).Level(zerolog.DebugLevel)
+	s := newTestService(t, map[string]interface{}{"allow_resharing": true})
+	ctx := appctx.WithLogger(user.ContextSetUser(context.Background(), einstein), &l)
+
+	req := newCreateShareRequest("/denied", nil)
+	req.Grant.Permissions.Permissions = conversions.NewCoownerRole().CS3ResourcePermissions()
+	req.ResourceInfo.PermissionSet = &provider.ResourcePermissions{Stat: true}
+
+	res, err := s.CreateShare(ctx, req)
+	if err != nil || res.Status.Code != rpc.Code_CODE_PERMISSION_DENIED {
+		t.Fatalf("expected the share to be denied: %v %v", err, res.GetStatus())
+	}
+
+	entry := map[string]interface{}{}
+	// the denial is the first entry, the status helper logs its own line after it
+	if err := json.NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&entry); err != nil {
+		t.Fatalf("error decoding log entry %q: %v", buf.String(), err)
+	}
+	for _, field := range []string{"requested_permissions", "resource_permissions", "decision"} {
+		if _, ok := entry[field]; !ok {
+			t.Errorf("log entry %q is missing field %s", buf.String(), field)
+		}
+	}
+	if entry["decision"] != "no permission to reshare" {
+		t.Errorf("unexpected decision %v", entry["decision"])
+	}
+}
+
+func TestUpdateReceivedSharePublishesStateChanges(t *testing.T) {
+	received := make(chan events.Event, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ev := events.Event{}
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+			t.Errorf("error decoding event: %v", err)
+		}
+		received <- ev
+	}))
+	defer srv.Close()
+
+	s := newTestService(t, map[string]interface{}{"state_change_webhook": srv.URL})
+	res, err := s.CreateShare(user.ContextSetUser(context.Background(), einstein), newCreateShareRequest("/notify", nil))
+	if err != nil || res.Status.Code != rpc.Code_CODE_OK {
+		t.Fatalf("error creating share: %v %v", err, res.GetStatus())
+	}
+
+	ctx := user.ContextSetUser(context.Background(), marie)
+	for _, state := range []collaboration.ShareState{
+		collaboration.ShareState_SHARE_STATE_ACCEPTED,
+		collaboration.ShareState_SHARE_STATE_REJECTED,
+	} {
+		ures, err := s.UpdateReceivedShare(ctx, &collaboration.UpdateReceivedShareRequest{
+			Ref: &collaboration.ShareReference{
+				Spec: &collaboration.ShareReference_Id{Id: res.Share.Id},
+			},
+			Field: &collaboration.UpdateReceivedShareRequest_UpdateField{
+				Field: &collaboration.UpdateReceivedShareRequest_UpdateField_State{State: state},
+			},
+		})
+		if err != nil || ures.Status.Code != rpc.Code_CODE_OK {
+			t.Fatalf("error updating received share: %v %v", err, ures.GetStatus())
+		}
+
+		select {
+		case ev := <-received:
+			data := ev.Data.(map[string]interface{})
+			if ev.Type != "share_state_changed" || data["share_id"] != res.Share.Id.OpaqueId || data["state"] != state.String() {
+				t.Errorf("unexpected event %+v", ev)
+			}
+			recipient := data["recipient"].(map[string]interface{})
+			if recipient["opaque_id"] != marie.Id.OpaqueId {
+				t.Errorf("event recipient %v is not %s", recipient, marie.Id.OpaqueId)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("no event published for state %s", state)
+		}
+	}
+}
+
+func TestUpdateReceivedShareDoesNotWaitForWebhook(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	s := newTestService(t, map[string]interface{}{"state_change_webhook": srv.URL})
+	res, err := s.CreateShare(user.ContextSetUser(context.Background(), einstein), newCreateShareRequest("/slow", nil))
+	if err != nil || res.Status.Code != rpc.Code_CODE_OK {
+		t.Fatalf("error creating share: %v %v", err, res.GetStatus())
+	}
+
+	start := time.Now()
+	ures, err := s.UpdateReceivedShare(user.ContextSetUser(context.Background(), marie), &collaboration.UpdateReceivedShareRequest{
+		Ref: &collaboration.ShareReference{
+			Spec: &collaboration.ShareReference_Id{Id: res.Share.Id},
+		},
+		Field: &collaboration.UpdateReceivedShareRequest_UpdateField{
+			Field: &collaboration.UpdateReceivedShareRequest_UpdateField_State{State: collaboration.ShareState_SHARE_STATE_ACCEPTED},
+		},
+	})
+	if err != nil || ures.Status.Code != rpc.Code_CODE_OK {
+		t.Fatalf("error updating received share: %v %v", err, ures.GetStatus())
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("accepting the share waited %s for the webhook", elapsed)
+	}
+}
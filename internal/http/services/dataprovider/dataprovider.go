@@ -21,8 +21,10 @@ package dataprovider
 import (
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/cs3org/reva/pkg/appctx"
+	"github.com/cs3org/reva/pkg/events"
 	datatxregistry "github.com/cs3org/reva/pkg/rhttp/datatx/manager/registry"
 	"github.com/cs3org/reva/pkg/rhttp/global"
 	"github.com/cs3org/reva/pkg/rhttp/router"
@@ -43,6 +45,11 @@ type config struct {
 	DataTXs  map[string]map[string]interface{} `mapstructure:"data_txs" docs:"url:pkg/rhttp/datatx/manager/simple/simple.go;The configuration for the data tx protocols"`
 	Timeout  int64                             `mapstructure:"timeout"`
 	Insecure bool                              `mapstructure:"insecure"`
+	// UploadFinishedWebhook is called with an event whenever an upload has been finished.
+	// Can be used to trigger post processing like virus scanning or thumbnail generation.
+	UploadFinishedWebhook string `mapstructure:"upload_finished_webhook"`
+	// UploadFinishedWebhookTimeout is the number of seconds to wait for the webhook
+	UploadFinishedWebhookTimeout int `mapstructure:"upload_finished_webhook_timeout"`
 }
 
 func (c *config) init() {
@@ -52,6 +59,9 @@ func (c *config) init() {
 	if c.Driver == "" {
 		c.Driver = "localhome"
 	}
+	if c.UploadFinishedWebhookTimeout == 0 {
+		c.UploadFinishedWebhookTimeout = 10
+	}
 }
 
 type svc struct {
@@ -75,7 +85,15 @@ func New(m map[string]interface{}, log *zerolog.Logger) (global.Service, error)
 		return nil, err
 	}
 
-	dataTXs, err := getDataTXs(conf, fs)
+	var publisher events.Publisher
+	if conf.UploadFinishedWebhook != "" {
+		// do not keep the client waiting for the webhook
+		publisher = events.NewAsyncPublisher(
+			events.NewWebhookPublisher(conf.UploadFinishedWebhook, time.Duration(conf.UploadFinishedWebhookTimeout)*time.Second),
+		)
+	}
+
+	dataTXs, err := getDataTXs(conf, fs, publisher)
 	if err != nil {
 		return nil, err
 	}
@@ -97,7 +115,7 @@ func getFS(c *config) (storage.FS, error) {
 	return nil, fmt.Errorf("driver not found: %s", c.Driver)
 }
 
-func getDataTXs(c *config, fs storage.FS) (map[string]http.Handler, error) {
+func getDataTXs(c *config, fs storage.FS, publisher events.Publisher) (map[string]http.Handler, error) {
 	if c.DataTXs == nil {
 		c.DataTXs = make(map[string]map[string]interface{})
 	}
@@ -109,7 +127,7 @@ func getDataTXs(c *config, fs storage.FS) (map[string]http.Handler, error) {
 	txs := make(map[string]http.Handler)
 	for t := range c.DataTXs {
 		if f, ok := datatxregistry.NewFuncs[t]; ok {
-			if tx, err := f(c.DataTXs[t]); err == nil {
+			if tx, err := f(c.DataTXs[t], publisher); err == nil {
 				if handler, err := tx.Handler(fs); err == nil {
 					txs[t] = handler
 				}
@@ -0,0 +1,212 @@
+// Copyright 2018-2021 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package ocdav
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/cs3org/reva/internal/http/services/datagateway"
+	"github.com/cs3org/reva/pkg/appctx"
+	"github.com/cs3org/reva/pkg/rhttp"
+)
+
+// archiveWriter adds the resources of a folder to an archive
+type archiveWriter interface {
+	addDir(name string, info *provider.ResourceInfo) error
+	addFile(name string, info *provider.ResourceInfo, content io.Reader) error
+	Close() error
+}
+
+// modTime returns the mtime of the resource, or the unix epoch if the storage did not report one
+func modTime(info *provider.ResourceInfo) time.Time {
+	return time.Unix(int64(info.GetMtime().GetSeconds()), int64(info.GetMtime().GetNanos()))
+}
+
+type zipArchive struct {
+	w *zip.Writer
+}
+
+func (a *zipArchive) addDir(name string, info *provider.ResourceInfo) error {
+	_, err := a.w.CreateHeader(&zip.FileHeader{
+		Name:     name + "/",
+		Method:   zip.Store,
+		Modified: modTime(info),
+	})
+	return err
+}
+
+func (a *zipArchive) addFile(name string, info *provider.ResourceInfo, content io.Reader) error {
+	w, err := a.w.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modTime(info),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, content)
+	return err
+}
+
+func (a *zipArchive) Close() error {
+	return a.w.Close()
+}
+
+type tarArchive struct {
+	w *tar.Writer
+}
+
+func (a *tarArchive) addDir(name string, info *provider.ResourceInfo) error {
+	return a.w.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     name + "/",
+		Mode:     0755,
+		ModTime:  modTime(info),
+	})
+}
+
+func (a *tarArchive) addFile(name string, info *provider.ResourceInfo, content io.Reader) error {
+	// tar needs the size up front, content that does not match it fails the archive
+	err := a.w.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(info.Size),
+		ModTime:  modTime(info),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(a.w, content)
+	return err
+}
+
+func (a *tarArchive) Close() error {
+	return a.w.Close()
+}
+
+// handleArchive streams the folder as zip or tar archive. Hidden resources are left out like in PROPFIND listings. The archive is written while the files are downloaded,
+// so it is never held in memory. Errors after the response status has been sent can only abort the archive,
+// which leaves the client with a truncated download.
+func (s *svc) handleArchive(w http.ResponseWriter, r *http.Request, client gateway.GatewayAPIClient, info *provider.ResourceInfo, format string) {
+	ctx := r.Context()
+	sublog := appctx.GetLogger(ctx).With().Str("path", info.Path).Str("format", format).Logger()
+
+	var aw archiveWriter
+	var contentType string
+	switch format {
+	case "zip":
+		aw, contentType = &zipArchive{w: zip.NewWriter(w)}, "application/zip"
+	case "tar":
+		aw, contentType = &tarArchive{w: tar.NewWriter(w)}, "application/x-tar"
+	default:
+		sublog.Debug().Msg("unsupported archive format")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	descendants, st, err := listDescendants(ctx, client, info.Path, nil, 0, s.hiddenBelow(info.Path))
+	switch {
+	case ctx.Err() != nil:
+		sublog.Debug().Err(ctx.Err()).Msg("archive download cancelled")
+		return
+	case err != nil:
+		sublog.Error().Err(err).Msg("error listing descendants")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	case st != nil:
+		HandleErrorStatus(&sublog, w, st)
+		return
+	}
+
+	name := path.Base(info.Path)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", "attachment; filename*=UTF-8''"+
+		name+"."+format+"; filename=\""+name+"."+format+"\"")
+	w.WriteHeader(http.StatusOK)
+
+	for _, d := range descendants {
+		entry := path.Join(name, strings.TrimPrefix(d.Path, info.Path))
+		if d.Type == provider.ResourceType_RESOURCE_TYPE_CONTAINER {
+			err = aw.addDir(entry, d)
+		} else {
+			err = s.archiveFile(ctx, client, aw, entry, d)
+		}
+		if err != nil {
+			sublog.Error().Err(err).Str("resource", d.Path).Msg("error adding resource to archive, aborting")
+			return
+		}
+	}
+	if err := aw.Close(); err != nil {
+		sublog.Error().Err(err).Msg("error finishing archive")
+	}
+}
+
+// archiveFile downloads the file and adds its content to the archive
+func (s *svc) archiveFile(ctx context.Context, client gateway.GatewayAPIClient, aw archiveWriter, name string, info *provider.ResourceInfo) error {
+	dReq := &provider.InitiateFileDownloadRequest{
+		Ref: &provider.Reference{
+			Spec: &provider.Reference_Path{Path: info.Path},
+		},
+	}
+	dRes, err := client.InitiateFileDownload(ctx, dReq)
+	if err != nil {
+		return err
+	}
+	if dRes.Status.Code != rpc.Code_CODE_OK {
+		return fmt.Errorf("status code %d", dRes.Status.Code)
+	}
+
+	var ep, token string
+	for _, p := range dRes.Protocols {
+		if p.Protocol == "simple" {
+			ep, token = p.DownloadEndpoint, p.Token
+		}
+	}
+	if ep == "" {
+		return fmt.Errorf("no simple download protocol for %s", info.Path)
+	}
+
+	httpReq, err := rhttp.NewRequest(ctx, "GET", ep, nil)
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set(datagateway.TokenTransportHeader, token)
+
+	httpRes, err := s.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer httpRes.Body.Close()
+	if httpRes.StatusCode != http.StatusOK {
+		return fmt.Errorf("status code %d", httpRes.StatusCode)
+	}
+	return aw.addFile(name, info, httpRes.Body)
+}
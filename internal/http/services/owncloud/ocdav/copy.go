@@ -24,6 +24,8 @@ import (
 	"net/http"
 	"path"
 	"strings"
+	"syscall"
+	"time"
 
 	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
 	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
@@ -32,9 +34,17 @@ import (
 	"github.com/cs3org/reva/internal/http/services/datagateway"
 	"github.com/cs3org/reva/pkg/appctx"
 	"github.com/cs3org/reva/pkg/rhttp"
+	"github.com/pkg/errors"
 	"go.opencensus.io/trace"
 )
 
+const (
+	// transferAttempts bounds the number of tries to copy a file
+	transferAttempts = 3
+	// transferBackoff is the wait before the first retry, it doubles with every retry
+	transferBackoff = 200 * time.Millisecond
+)
+
 func (s *svc) handleCopy(w http.ResponseWriter, r *http.Request, ns string) {
 	ctx := r.Context()
 	ctx, span := trace.StartSpan(ctx, "head")
@@ -68,6 +78,12 @@ func (s *svc) handleCopy(w http.ResponseWriter, r *http.Request, ns string) {
 		return
 	}
 
+	if isDescendant(src, dst) {
+		sublog.Debug().Msg("destination is a descendant of the source")
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
 	if depth != "infinity" && depth != "0" {
 		w.WriteHeader(http.StatusBadRequest)
 		return
@@ -149,6 +165,34 @@ func (s *svc) handleCopy(w http.ResponseWriter, r *http.Request, ns string) {
 		// TODO what if intermediate is a file?
 	}
 
+	// fail before copying anything instead of leaving a partial copy behind
+	var overwritten *provider.ResourceInfo
+	if dstStatRes.Status.Code == rpc.Code_CODE_OK {
+		overwritten = dstStatRes.Info
+	}
+	exceeded, err := exceedsQuota(ctx, client, srcStatRes.Info, depth == "infinity", overwritten, path.Dir(dst))
+	if err != nil {
+		sublog.Error().Err(err).Msg("error checking the quota of the destination")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if exceeded {
+		sublog.Debug().Msg("copy exceeds the quota of the destination")
+		w.WriteHeader(http.StatusInsufficientStorage)
+		b, err := Marshal(exception{
+			code:    SabredavInsufficientStorage,
+			message: "The destination does not have enough free space for the copy.",
+		})
+		if err != nil {
+			sublog.Error().Msgf("error marshaling xml response: %s", b)
+			return
+		}
+		if _, err = w.Write(b); err != nil {
+			sublog.Err(err).Msg("error writing response")
+		}
+		return
+	}
+
 	err = s.descend(ctx, client, srcStatRes.Info, dst, depth == "infinity")
 	if err != nil {
 		sublog.Error().Err(err).Str("depth", depth).Msg("error descending directory")
@@ -158,6 +202,65 @@ func (s *svc) handleCopy(w http.ResponseWriter, r *http.Request, ns string) {
 	w.WriteHeader(successCode)
 }
 
+// exceedsQuota checks if the copy of src fits into the free space of the parent container of the destination.
+// The space used by an overwritten destination is freed by the copy. Storages that do not report a quota are not limited.
+func exceedsQuota(ctx context.Context, client gateway.GatewayAPIClient, src *provider.ResourceInfo, recurse bool, overwritten *provider.ResourceInfo, parent string) (bool, error) {
+	// without recursion only the empty container is created
+	if !recurse && src.Type == provider.ResourceType_RESOURCE_TYPE_CONTAINER {
+		return false, nil
+	}
+	res, err := client.GetQuota(ctx, &gateway.GetQuotaRequest{
+		Ref: &provider.Reference{
+			Spec: &provider.Reference_Path{Path: parent},
+		},
+	})
+	if err != nil {
+		return false, errors.Wrap(err, "error sending grpc get quota request")
+	}
+	if res.Status.Code != rpc.Code_CODE_OK || res.TotalBytes == 0 {
+		return false, nil
+	}
+	var free uint64
+	if res.TotalBytes > res.UsedBytes {
+		free = res.TotalBytes - res.UsedBytes
+	}
+	if overwritten != nil {
+		freed, err := treeSize(ctx, client, overwritten)
+		if err != nil {
+			return false, err
+		}
+		free += freed
+	}
+
+	size, err := treeSize(ctx, client, src)
+	if err != nil {
+		return false, err
+	}
+	return size > free, nil
+}
+
+// treeSize returns the size of the resource and all its descendants. Containers are summed up from their
+// descendants when they report no size, because not every storage propagates the size of a tree.
+func treeSize(ctx context.Context, client gateway.GatewayAPIClient, info *provider.ResourceInfo) (uint64, error) {
+	if info.Type != provider.ResourceType_RESOURCE_TYPE_CONTAINER || info.GetSize() > 0 {
+		return info.GetSize(), nil
+	}
+	descendants, st, err := listDescendants(ctx, client, info.Path, nil, 0, nil)
+	if err != nil {
+		return 0, err
+	}
+	if st != nil {
+		return 0, errors.Errorf("error listing the descendants of %s: %s", info.Path, st.Message)
+	}
+	var size uint64
+	for _, d := range descendants {
+		if d.Type != provider.ResourceType_RESOURCE_TYPE_CONTAINER {
+			size += d.GetSize()
+		}
+	}
+	return size, nil
+}
+
 func (s *svc) descend(ctx context.Context, client gateway.GatewayAPIClient, src *provider.ResourceInfo, dst string, recurse bool) error {
 	log := appctx.GetLogger(ctx)
 	log.Debug().Str("src", src.Path).Str("dst", dst).Msg("descending")
@@ -175,30 +278,47 @@ func (s *svc) descend(ctx context.Context, client gateway.GatewayAPIClient, src
 
 		// TODO: also copy properties: https://tools.ietf.org/html/rfc4918#section-9.8.2
 
-		if !recurse {
-			return nil
-		}
+		if recurse {
+			// descend for children
+			listReq := &provider.ListContainerRequest{
+				Ref: &provider.Reference{
+					Spec: &provider.Reference_Path{Path: src.Path},
+				},
+			}
+			res, err := client.ListContainer(ctx, listReq)
+			if err != nil {
+				return err
+			}
+			if res.Status.Code != rpc.Code_CODE_OK {
+				return fmt.Errorf("status code %d", res.Status.Code)
+			}
 
-		// descend for children
-		listReq := &provider.ListContainerRequest{
-			Ref: &provider.Reference{
-				Spec: &provider.Reference_Path{Path: src.Path},
-			},
-		}
-		res, err := client.ListContainer(ctx, listReq)
-		if err != nil {
-			return err
-		}
-		if res.Status.Code != rpc.Code_CODE_OK {
-			return fmt.Errorf("status code %d", res.Status.Code)
+			for i := range res.Infos {
+				childDst := path.Join(dst, path.Base(res.Infos[i].Path))
+				err := s.descend(ctx, client, res.Infos[i], childDst, recurse)
+				if err != nil {
+					return err
+				}
+			}
 		}
 
-		for i := range res.Infos {
-			childDst := path.Join(dst, path.Base(res.Infos[i].Path))
-			err := s.descend(ctx, client, res.Infos[i], childDst, recurse)
+		// creating the children changes the mtime of the container, so it can only be set afterwards
+		if s.c.PreserveCopyMtime && src.Mtime != nil {
+			mdReq := &provider.SetArbitraryMetadataRequest{
+				Ref: &provider.Reference{
+					Spec: &provider.Reference_Path{Path: dst},
+				},
+				ArbitraryMetadata: &provider.ArbitraryMetadata{
+					Metadata: map[string]string{"mtime": mtimeString(src.Mtime)},
+				},
+			}
+			mdRes, err := client.SetArbitraryMetadata(ctx, mdReq)
 			if err != nil {
 				return err
 			}
+			if mdRes.Status.Code != rpc.Code_CODE_OK {
+				return fmt.Errorf("status code %d", mdRes.Status.Code)
+			}
 		}
 
 	} else {
@@ -228,7 +348,7 @@ func (s *svc) descend(ctx context.Context, client gateway.GatewayAPIClient, src
 			}
 		}
 
-		// 2. get upload url
+		// 2. prepare the upload
 
 		uReq := &provider.InitiateFileUploadRequest{
 			Ref: &provider.Reference{
@@ -245,57 +365,105 @@ func (s *svc) descend(ctx context.Context, client gateway.GatewayAPIClient, src
 			},
 		}
 
-		uRes, err := client.InitiateFileUpload(ctx, uReq)
-		if err != nil {
-			return err
-		}
-
-		if uRes.Status.Code != rpc.Code_CODE_OK {
-			return fmt.Errorf("status code %d", uRes.Status.Code)
-		}
-
-		var uploadEP, uploadToken string
-		for _, p := range uRes.Protocols {
-			if p.Protocol == "simple" {
-				uploadEP, uploadToken = p.UploadEndpoint, p.Token
+		if s.c.PreserveCopyMtime && src.Mtime != nil {
+			uReq.Opaque.Map["X-OC-Mtime"] = &typespb.OpaqueEntry{
+				Decoder: "plain",
+				Value:   []byte(mtimeString(src.Mtime)),
 			}
 		}
 
-		// 3. do download
+		// 3. do download and 4. do upload, every attempt initiates its own upload
 
-		httpDownloadReq, err := rhttp.NewRequest(ctx, "GET", downloadEP, nil)
-		if err != nil {
+		if err := s.transfer(ctx, client, uReq, downloadEP, downloadToken, src.GetSize()); err != nil {
 			return err
 		}
-		httpDownloadReq.Header.Set(datagateway.TokenTransportHeader, downloadToken)
+	}
+	return nil
+}
 
-		httpDownloadRes, err := s.client.Do(httpDownloadReq)
-		if err != nil {
+// mtimeString formats a timestamp the way the X-OC-Mtime header and the mtime metadata expect it
+func mtimeString(ts *typespb.Timestamp) string {
+	return fmt.Sprintf("%d.%09d", ts.Seconds, ts.Nanos)
+}
+
+// transfer copies a file from the download endpoint to a new upload initiated with uReq. Transient errors of the
+// data service are retried with an exponential backoff, so a recursive copy survives brief hiccups.
+func (s *svc) transfer(ctx context.Context, client gateway.GatewayAPIClient, uReq *provider.InitiateFileUploadRequest, downloadEP, downloadToken string, size uint64) error {
+	backoff := transferBackoff
+	for attempt := 1; ; attempt++ {
+		retryable, err := s.transferOnce(ctx, client, uReq, downloadEP, downloadToken, size)
+		if err == nil || !retryable || attempt == transferAttempts {
 			return err
 		}
-		defer httpDownloadRes.Body.Close()
-		if httpDownloadRes.StatusCode != http.StatusOK {
-			return fmt.Errorf("status code %d", httpDownloadRes.StatusCode)
+		appctx.GetLogger(ctx).Warn().Err(err).Int("attempt", attempt).Msg("transient error copying file, retrying")
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
 		}
+		backoff *= 2
+	}
+}
 
-		// 4. do upload
+// transferOnce copies a file once and reports if a failed transfer can be retried. A failed upload may have consumed
+// its upload session, so the upload is initiated anew for every attempt.
+func (s *svc) transferOnce(ctx context.Context, client gateway.GatewayAPIClient, uReq *provider.InitiateFileUploadRequest, downloadEP, downloadToken string, size uint64) (bool, error) {
+	uRes, err := client.InitiateFileUpload(ctx, uReq)
+	if err != nil {
+		return false, err
+	}
 
-		if src.GetSize() > 0 {
-			httpUploadReq, err := rhttp.NewRequest(ctx, "PUT", uploadEP, httpDownloadRes.Body)
-			if err != nil {
-				return err
-			}
-			httpUploadReq.Header.Set(datagateway.TokenTransportHeader, uploadToken)
+	if uRes.Status.Code != rpc.Code_CODE_OK {
+		return false, fmt.Errorf("status code %d", uRes.Status.Code)
+	}
 
-			httpUploadRes, err := s.client.Do(httpUploadReq)
-			if err != nil {
-				return err
-			}
-			defer httpUploadRes.Body.Close()
-			if httpUploadRes.StatusCode != http.StatusOK {
-				return err
-			}
+	var uploadEP, uploadToken string
+	for _, p := range uRes.Protocols {
+		if p.Protocol == "simple" {
+			uploadEP, uploadToken = p.UploadEndpoint, p.Token
 		}
 	}
-	return nil
+
+	httpDownloadReq, err := rhttp.NewRequest(ctx, "GET", downloadEP, nil)
+	if err != nil {
+		return false, err
+	}
+	httpDownloadReq.Header.Set(datagateway.TokenTransportHeader, downloadToken)
+
+	httpDownloadRes, err := s.client.Do(httpDownloadReq)
+	if err != nil {
+		return errors.Is(err, syscall.ECONNRESET), err
+	}
+	defer httpDownloadRes.Body.Close()
+	if httpDownloadRes.StatusCode != http.StatusOK {
+		return isTransientStatus(httpDownloadRes.StatusCode), fmt.Errorf("status code %d", httpDownloadRes.StatusCode)
+	}
+
+	if size == 0 {
+		return false, nil
+	}
+
+	httpUploadReq, err := rhttp.NewRequest(ctx, "PUT", uploadEP, httpDownloadRes.Body)
+	if err != nil {
+		return false, err
+	}
+	httpUploadReq.Header.Set(datagateway.TokenTransportHeader, uploadToken)
+
+	httpUploadRes, err := s.client.Do(httpUploadReq)
+	if err != nil {
+		return errors.Is(err, syscall.ECONNRESET), err
+	}
+	defer httpUploadRes.Body.Close()
+	if httpUploadRes.StatusCode != http.StatusOK {
+		return isTransientStatus(httpUploadRes.StatusCode), fmt.Errorf("status code %d", httpUploadRes.StatusCode)
+	}
+	return false, nil
+}
+
+func isTransientStatus(code int) bool {
+	switch code {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
 }
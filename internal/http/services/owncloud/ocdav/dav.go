@@ -87,6 +87,17 @@ func isOwner(userIDorName string, user *userv1beta1.User) bool {
 	return userIDorName != "" && (userIDorName == user.Id.OpaqueId || strings.EqualFold(userIDorName, user.Username))
 }
 
+// filesRootMessage explains why a request to the files collection root was rejected.
+// Only reading requests are about listing its members, writes are not possible at all.
+func filesRootMessage(method string) string {
+	switch method {
+	case "PROPFIND", http.MethodGet, http.MethodHead:
+		return "Listing members of this collection is disabled"
+	default:
+		return method + " is not allowed on this collection, use the files collection of a user"
+	}
+}
+
 // Handler handles requests
 func (h *DavHandler) Handler(s *svc) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -106,7 +117,7 @@ func (h *DavHandler) Handler(s *svc) http.Handler {
 				w.WriteHeader(http.StatusMethodNotAllowed)
 				b, err := Marshal(exception{
 					code:    SabredavMethodNotAllowed,
-					message: "Listing members of this collection is disabled",
+					message: filesRootMessage(r.Method),
 				})
 				if err != nil {
 					log.Error().Msgf("error marshaling xml response: %s", b)
@@ -37,6 +37,10 @@ const (
 	SabredavMethodNotAllowed
 	// SabredavMethodNotAuthenticated maps to HTTP 401
 	SabredavMethodNotAuthenticated
+	// SabredavInsufficientStorage maps to HTTP 507
+	SabredavInsufficientStorage
+	// SabredavForbidden maps to HTTP 403
+	SabredavForbidden
 )
 
 var (
@@ -44,22 +48,30 @@ var (
 		"Sabre\\DAV\\Exception\\BadRequest",
 		"Sabre\\DAV\\Exception\\MethodNotAllowed",
 		"Sabre\\DAV\\Exception\\NotAuthenticated",
+		"Sabre\\DAV\\Exception\\InsufficientStorage",
+		"Sabre\\DAV\\Exception\\Forbidden",
 	}
 )
 
 type exception struct {
 	code    code
 	message string
+	// precondition is the name of a failed DAV precondition, eg. propfind-finite-depth
+	precondition string
 }
 
 // Marshal just calls the xml marshaller for a given exception.
 func Marshal(e exception) ([]byte, error) {
-	return xml.Marshal(&errorXML{
+	x := &errorXML{
 		Xmlnsd:    "DAV",
 		Xmlnss:    "http://sabredav.org/ns",
 		Exception: codesEnum[e.code],
 		Message:   e.message,
-	})
+	}
+	if e.precondition != "" {
+		x.InnerXML = []byte("<d:" + e.precondition + "/>")
+	}
+	return xml.Marshal(x)
 }
 
 // http://www.webdav.org/specs/rfc4918.html#ELEMENT_error
@@ -101,3 +113,20 @@ func HandleErrorStatus(log *zerolog.Logger, w http.ResponseWriter, s *rpc.Status
 		w.WriteHeader(http.StatusInternalServerError)
 	}
 }
+
+// writeBadRequest responds with a 400 and a sabredav exception carrying the message
+func writeBadRequest(log *zerolog.Logger, w http.ResponseWriter, message string) {
+	b, err := Marshal(exception{
+		code:    SabredavMethodBadRequest,
+		message: message,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("error marshaling xml response")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusBadRequest)
+	if _, err := w.Write(b); err != nil {
+		log.Err(err).Msg("error writing response")
+	}
+}
@@ -73,6 +73,11 @@ func (s *svc) handleGet(w http.ResponseWriter, r *http.Request, ns string) {
 
 	info := sRes.Info
 	if info.Type == provider.ResourceType_RESOURCE_TYPE_CONTAINER {
+		// folders can only be downloaded as archive
+		if format := r.URL.Query().Get("format"); format != "" {
+			s.handleArchive(w, r, client, info, format)
+			return
+		}
 		sublog.Warn().Msg("resource is a folder and cannot be downloaded")
 		w.WriteHeader(http.StatusNotImplemented)
 		return
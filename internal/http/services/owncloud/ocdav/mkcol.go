@@ -22,10 +22,12 @@ import (
 	"io"
 	"net/http"
 	"path"
+	"time"
 
 	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
 	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
 	"github.com/cs3org/reva/pkg/appctx"
+	"github.com/cs3org/reva/pkg/utils"
 	"go.opencensus.io/trace"
 )
 
@@ -83,6 +85,19 @@ func (s *svc) handleMkcol(w http.ResponseWriter, r *http.Request, ns string) {
 	}
 	switch res.Status.Code {
 	case rpc.Code_CODE_OK:
+		// let clients cache the new collection right away, the collection has been created even if the stat fails
+		statRes, err = client.Stat(ctx, statReq)
+		switch {
+		case err != nil:
+			sublog.Error().Err(err).Msg("error sending a grpc stat request")
+		case statRes.Status.Code != rpc.Code_CODE_OK:
+			sublog.Error().Interface("status", statRes.Status).Msg("error stating created collection")
+		default:
+			w.Header().Set("ETag", statRes.Info.Etag)
+			w.Header().Set("OC-ETag", statRes.Info.Etag)
+			w.Header().Set("OC-FileId", wrapResourceID(statRes.Info.Id))
+			w.Header().Set("Last-Modified", utils.TSToTime(statRes.Info.Mtime).UTC().Format(time.RFC1123Z))
+		}
 		w.WriteHeader(http.StatusCreated)
 	case rpc.Code_CODE_NOT_FOUND:
 		sublog.Debug().Str("path", fn).Interface("status", statRes.Status).Msg("conflict")
@@ -58,6 +58,12 @@ func (s *svc) handleMove(w http.ResponseWriter, r *http.Request, ns string) {
 		return
 	}
 
+	if isDescendant(src, dst) {
+		sublog.Debug().Msg("destination is a descendant of the source")
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
 	client, err := s.getClient()
 	if err != nil {
 		sublog.Error().Err(err).Msg("error getting grpc client")
@@ -73,11 +73,68 @@ type Config struct {
 	Timeout         int64  `mapstructure:"timeout"`
 	Insecure        bool   `mapstructure:"insecure"`
 	PublicURL       string `mapstructure:"public_url"`
+	// AllpropMetadataKeys lists the arbitrary metadata keys that are fetched for an allprop PROPFIND.
+	// Additional keys can be requested by clients using the DAV:include element.
+	AllpropMetadataKeys []string `mapstructure:"allprop_metadata_keys"`
+	// AllpropIncludeAllMetadata makes allprop PROPFIND requests fetch all arbitrary metadata keys.
+	// Be aware that this might expose internal metadata to clients.
+	AllpropIncludeAllMetadata bool `mapstructure:"allprop_include_all_metadata"`
+	// EffectiveContainerMtime makes a Depth 1 PROPFIND report the latest mtime of a container and its children
+	// as the mtime of the container. Useful when the storage propagates mtimes asynchronously.
+	EffectiveContainerMtime bool `mapstructure:"effective_container_mtime"`
+	// MaxUploadSize is the maximum size in bytes of a single upload, 0 means unlimited.
+	// Uploads declaring a larger size are rejected before any content is transferred. This is independent of the quota.
+	MaxUploadSize int64 `mapstructure:"max_upload_size"`
+	// IconClasses maps mimetypes to the icon class returned in the oc:icon-class PROPFIND property.
+	// Keys are either full mimetypes like application/pdf or major types with a trailing slash like image/.
+	IconClasses map[string]string `mapstructure:"icon_classes"`
+	// PreviewMimeTypes lists the mimetypes for which previews can be generated. It is used to compute the oc:has-preview
+	// PROPFIND property. Like for IconClasses entries are either full mimetypes or major types with a trailing slash.
+	PreviewMimeTypes []string `mapstructure:"preview_mimetypes"`
+	// UploadMetadataKeys lists the TUS Upload-Metadata keys that are persisted as arbitrary metadata of the uploaded file.
+	// They are stored in the owncloud namespace, a PROPFIND for oc:foo fetches and returns the value of a configured key foo.
+	UploadMetadataKeys []string `mapstructure:"upload_metadata_keys"`
+	// MaxPropfindResults limits the number of resources returned by a single PROPFIND, 0 means unlimited.
+	// Truncated listings end with a response carrying a 507 status.
+	MaxPropfindResults int `mapstructure:"max_propfind_results"`
+	// PreserveCopyMtime keeps the mtime of the source files and folders on COPY.
+	// By default copies get the time of the copy as their mtime.
+	PreserveCopyMtime bool `mapstructure:"preserve_copy_mtime"`
+	// HiddenPatterns lists glob patterns, e.g. ".*" for dotfiles, of names that are left out of PROPFIND listings.
+	// Hidden resources can still be accessed directly.
+	HiddenPatterns []string `mapstructure:"hidden_patterns"`
+	// DefaultPropfindDepth is used for PROPFIND requests without a Depth header. It can be 0, 1 or infinity
+	// and defaults to 1.
+	DefaultPropfindDepth string `mapstructure:"default_propfind_depth"`
 }
 
 func (c *Config) init() {
 	// note: default c.Prefix is an empty string
 	c.GatewaySvc = sharedconf.GetGatewaySVC(c.GatewaySvc)
+
+	if len(c.AllpropMetadataKeys) == 0 {
+		c.AllpropMetadataKeys = []string{_propOcFavorite}
+	}
+
+	if c.DefaultPropfindDepth == "" {
+		c.DefaultPropfindDepth = "1"
+	}
+
+	if c.PreviewMimeTypes == nil {
+		c.PreviewMimeTypes = []string{"image/gif", "image/jpeg", "image/png", "text/plain"}
+	}
+
+	if c.IconClasses == nil {
+		c.IconClasses = map[string]string{
+			"httpd/unix-directory": "icon-folder",
+			"application/pdf":      "icon-pdf",
+			"application/zip":      "icon-archive",
+			"audio/":               "icon-audio",
+			"image/":               "icon-image",
+			"text/":                "icon-text",
+			"video/":               "icon-video",
+		}
+	}
 }
 
 type svc struct {
@@ -96,6 +153,18 @@ func New(m map[string]interface{}, log *zerolog.Logger) (global.Service, error)
 
 	conf.init()
 
+	switch conf.DefaultPropfindDepth {
+	case "0", "1", "infinity":
+	default:
+		return nil, errors.New("ocdav: invalid default propfind depth " + conf.DefaultPropfindDepth)
+	}
+
+	for _, p := range conf.HiddenPatterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, errors.Wrap(err, "ocdav: invalid hidden pattern "+p)
+		}
+	}
+
 	s := &svc{
 		c:             conf,
 		webDavHandler: new(WebDavHandler),
@@ -282,6 +351,18 @@ func extractDestination(dstHeader, baseURI string) (string, error) {
 	return urlSplit[1], nil
 }
 
+// isDescendant checks if dst is located below src, eg. /a/b/c is a descendant of /a/b.
+// A path is not considered to be its own descendant. MOVE and COPY use it to reject
+// a collection being moved or copied into its own subtree, which would recurse endlessly.
+func isDescendant(src, dst string) bool {
+	src = path.Clean(src)
+	dst = path.Clean(dst)
+	if src == "/" {
+		return dst != "/"
+	}
+	return strings.HasPrefix(dst, src+"/")
+}
+
 // replaceAllStringSubmatchFunc is taken from 'Go: Replace String with Regular Expression Callback'
 // see: https://elliotchance.medium.com/go-replace-string-with-regular-expression-callback-f89948bad0bb
 func replaceAllStringSubmatchFunc(re *regexp.Regexp, str string, repl func([]string) string) string {
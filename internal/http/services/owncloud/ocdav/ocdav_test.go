@@ -0,0 +1,1595 @@
+// Copyright 2018-2021 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package ocdav
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	typespb "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
+	"github.com/cs3org/reva/internal/grpc/services/storageprovider"
+	"github.com/cs3org/reva/internal/http/services/datagateway"
+	"github.com/cs3org/reva/pkg/rgrpc/status"
+	"github.com/cs3org/reva/pkg/rgrpc/todo/pool"
+	_ "github.com/cs3org/reva/pkg/storage/fs/local"
+	"github.com/cs3org/reva/pkg/user"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+)
+
+// testGateway answers the gateway calls made by the handlers under test
+type testGateway struct {
+	gateway.UnimplementedGatewayAPIServer
+	stat                 func(*provider.StatRequest) *provider.StatResponse
+	initiateFileUpload   func(*provider.InitiateFileUploadRequest) *gateway.InitiateFileUploadResponse
+	listContainer        func(*provider.ListContainerRequest) *provider.ListContainerResponse
+	createContainer      func(*provider.CreateContainerRequest) *provider.CreateContainerResponse
+	initiateFileDownload func(*provider.InitiateFileDownloadRequest) *gateway.InitiateFileDownloadResponse
+	setArbitraryMetadata func(*provider.SetArbitraryMetadataRequest) *provider.SetArbitraryMetadataResponse
+	getPath              func(*provider.GetPathRequest) *provider.GetPathResponse
+	getQuota             func(*gateway.GetQuotaRequest) *provider.GetQuotaResponse
+}
+
+func (g *testGateway) Stat(ctx context.Context, req *provider.StatRequest) (*provider.StatResponse, error) {
+	return g.stat(req), nil
+}
+
+func (g *testGateway) InitiateFileUpload(ctx context.Context, req *provider.InitiateFileUploadRequest) (*gateway.InitiateFileUploadResponse, error) {
+	return g.initiateFileUpload(req), nil
+}
+
+func (g *testGateway) ListContainer(ctx context.Context, req *provider.ListContainerRequest) (*provider.ListContainerResponse, error) {
+	return g.listContainer(req), nil
+}
+
+func (g *testGateway) CreateContainer(ctx context.Context, req *provider.CreateContainerRequest) (*provider.CreateContainerResponse, error) {
+	return g.createContainer(req), nil
+}
+
+func (g *testGateway) InitiateFileDownload(ctx context.Context, req *provider.InitiateFileDownloadRequest) (*gateway.InitiateFileDownloadResponse, error) {
+	return g.initiateFileDownload(req), nil
+}
+
+func (g *testGateway) SetArbitraryMetadata(ctx context.Context, req *provider.SetArbitraryMetadataRequest) (*provider.SetArbitraryMetadataResponse, error) {
+	return g.setArbitraryMetadata(req), nil
+}
+
+func (g *testGateway) GetPath(ctx context.Context, req *provider.GetPathRequest) (*provider.GetPathResponse, error) {
+	return g.getPath(req), nil
+}
+
+func (g *testGateway) GetQuota(ctx context.Context, req *gateway.GetQuotaRequest) (*provider.GetQuotaResponse, error) {
+	if g.getQuota == nil {
+		return &provider.GetQuotaResponse{Status: status.NewUnimplemented(ctx, nil, "no quota")}, nil
+	}
+	return g.getQuota(req), nil
+}
+
+// newTestService returns an ocdav service talking to the given gateway
+func newTestService(t *testing.T, g *testGateway, c *Config) *svc {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	srv := grpc.NewServer()
+	gateway.RegisterGatewayAPIServer(srv, g)
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+
+	c.GatewaySvc = lis.Addr().String()
+	c.init()
+	return &svc{c: c, client: http.DefaultClient}
+}
+
+func TestIsDescendant(t *testing.T) {
+	tests := []struct {
+		src      string
+		dst      string
+		expected bool
+	}{
+		{"/a/b", "/a/b/c", true},
+		{"/a/b", "/a/b/c/d", true},
+		{"/a/b/", "/a/b/c", true},
+		{"/a/b", "/a/b", false},
+		{"/a/b", "/a/bc", false},
+		{"/a/b", "/a", false},
+		{"/a/b", "/x/a/b", false},
+		{"/", "/a", true},
+		{"/", "/", false},
+	}
+
+	for _, tt := range tests {
+		if got := isDescendant(tt.src, tt.dst); got != tt.expected {
+			t.Errorf("isDescendant(\"%s\", \"%s\") returned %t instead of expected %t", tt.src, tt.dst, got, tt.expected)
+		}
+	}
+}
+
+func TestMoveCopyIntoDescendant(t *testing.T) {
+	s := &svc{c: &Config{}}
+	handlers := map[string]func(http.ResponseWriter, *http.Request, string){
+		"MOVE": s.handleMove,
+		"COPY": s.handleCopy,
+	}
+
+	for method, handler := range handlers {
+		r := httptest.NewRequest(method, "/folder", nil)
+		r.Header.Set("Destination", "http://localhost/remote.php/webdav/folder/subfolder/folder")
+		r = r.WithContext(context.WithValue(r.Context(), ctxKeyBaseURI, "/remote.php/webdav"))
+		w := httptest.NewRecorder()
+
+		handler(w, r, "/home")
+
+		if w.Code != http.StatusConflict {
+			t.Errorf("%s into own subfolder returned %d instead of expected %d", method, w.Code, http.StatusConflict)
+		}
+	}
+}
+
+func TestAllpropMetadataKeys(t *testing.T) {
+	c := &Config{}
+	c.init()
+	s := &svc{c: c}
+
+	custom := xml.Name{Space: "http://example.com/ns", Local: "secret"}
+	customKey := metadataKeyOf(&custom)
+
+	keys := s.metadataKeys(&propfindXML{Allprop: new(struct{})})
+	if !contains(keys, _propOcFavorite) {
+		t.Errorf("allprop metadata keys %v do not contain the default key %s", keys, _propOcFavorite)
+	}
+	if contains(keys, "*") || contains(keys, customKey) {
+		t.Errorf("allprop metadata keys %v must not request all or custom keys", keys)
+	}
+
+	keys = s.metadataKeys(&propfindXML{Allprop: new(struct{}), Include: propfindProps{custom}})
+	if !contains(keys, customKey) {
+		t.Errorf("allprop metadata keys %v do not contain the included key %s", keys, customKey)
+	}
+
+	s.c.AllpropIncludeAllMetadata = true
+	keys = s.metadataKeys(&propfindXML{Allprop: new(struct{})})
+	if len(keys) != 1 || keys[0] != "*" {
+		t.Errorf("allprop metadata keys %v should only request all keys", keys)
+	}
+}
+
+func TestPropfindReusesMetadataKeys(t *testing.T) {
+	ctx := context.Background()
+	tree := &treeClient{depth: 3}
+	var requested [][]string
+	g := &testGateway{
+		stat: func(req *provider.StatRequest) *provider.StatResponse {
+			requested = append(requested, req.ArbitraryMetadataKeys)
+			return &provider.StatResponse{Status: status.NewOK(ctx), Info: &provider.ResourceInfo{
+				Type: provider.ResourceType_RESOURCE_TYPE_CONTAINER,
+				Path: req.Ref.GetPath(),
+			}}
+		},
+		listContainer: func(req *provider.ListContainerRequest) *provider.ListContainerResponse {
+			requested = append(requested, req.ArbitraryMetadataKeys)
+			res, _ := tree.ListContainer(ctx, req)
+			return res
+		},
+	}
+	s := newTestService(t, g, &Config{})
+
+	body := `<?xml version="1.0"?>
+<d:propfind xmlns:d="DAV:" xmlns:oc="http://owncloud.org/ns" xmlns:x="http://example.com/ns">
+  <d:prop><oc:favorite/><x:secret/><oc:favorite/><x:secret/></d:prop>
+</d:propfind>`
+	r := httptest.NewRequest("PROPFIND", "/folder", strings.NewReader(body))
+	r.Header.Set("Depth", "infinity")
+	r = r.WithContext(context.WithValue(r.Context(), ctxKeyBaseURI, "/remote.php/webdav"))
+	w := httptest.NewRecorder()
+	s.handlePropfind(w, r, "/home")
+
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("PROPFIND returned %d instead of expected %d", w.Code, http.StatusMultiStatus)
+	}
+	if len(requested) < 3 {
+		t.Fatalf("expected a stat and several list calls, got %d calls", len(requested))
+	}
+	keys := requested[0]
+	if len(keys) != 2 || keys[0] == keys[1] {
+		t.Fatalf("unexpected metadata keys %v", keys)
+	}
+	for _, k := range requested[1:] {
+		if strings.Join(k, " ") != strings.Join(keys, " ") {
+			t.Errorf("metadata keys %v differ from the stat keys %v", k, keys)
+		}
+	}
+}
+
+func TestPropfindFolderSize(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		treesize string
+		expected string
+	}{
+		{"4096", "<oc:size>4096</oc:size>"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		g := &testGateway{
+			stat: func(req *provider.StatRequest) *provider.StatResponse {
+				return &provider.StatResponse{Status: status.NewOK(ctx), Info: &provider.ResourceInfo{
+					Type: provider.ResourceType_RESOURCE_TYPE_CONTAINER,
+					Path: req.Ref.GetPath(),
+					Opaque: &typespb.Opaque{Map: map[string]*typespb.OpaqueEntry{
+						"treesize": {Decoder: "plain", Value: []byte(tt.treesize)},
+					}},
+				}}
+			},
+		}
+		s := newTestService(t, g, &Config{})
+
+		r := httptest.NewRequest("PROPFIND", "/folder", nil)
+		r.Header.Set("Depth", "0")
+		r = r.WithContext(context.WithValue(r.Context(), ctxKeyBaseURI, "/remote.php/webdav"))
+		w := httptest.NewRecorder()
+		s.handlePropfind(w, r, "/home")
+
+		if w.Code != http.StatusMultiStatus {
+			t.Fatalf("PROPFIND returned %d instead of expected %d", w.Code, http.StatusMultiStatus)
+		}
+		body := w.Body.String()
+		if tt.expected == "" && strings.Contains(body, "<oc:size>") {
+			t.Errorf("PROPFIND returned a size for an unknown treesize: %s", body)
+		}
+		if tt.expected != "" && !strings.Contains(body, tt.expected) {
+			t.Errorf("PROPFIND did not return the treesize %s: %s", tt.treesize, body)
+		}
+	}
+}
+
+func TestPropfindHiddenPatterns(t *testing.T) {
+	ctx := context.Background()
+	g := &testGateway{
+		stat: func(req *provider.StatRequest) *provider.StatResponse {
+			return &provider.StatResponse{Status: status.NewOK(ctx), Info: &provider.ResourceInfo{
+				Type: provider.ResourceType_RESOURCE_TYPE_CONTAINER,
+				Path: req.Ref.GetPath(),
+			}}
+		},
+		listContainer: func(req *provider.ListContainerRequest) *provider.ListContainerResponse {
+			res := &provider.ListContainerResponse{Status: status.NewOK(ctx)}
+			for _, name := range []string{"visible", ".hidden"} {
+				res.Infos = append(res.Infos, &provider.ResourceInfo{
+					Type: provider.ResourceType_RESOURCE_TYPE_FILE,
+					Path: path.Join(req.Ref.GetPath(), name),
+				})
+			}
+			return res
+		},
+	}
+	s := newTestService(t, g, &Config{HiddenPatterns: []string{".*"}})
+
+	propfind := func(p, depth string) string {
+		r := httptest.NewRequest("PROPFIND", p, nil)
+		r.Header.Set("Depth", depth)
+		r = r.WithContext(context.WithValue(r.Context(), ctxKeyBaseURI, "/remote.php/webdav"))
+		w := httptest.NewRecorder()
+		s.handlePropfind(w, r, "/home")
+		if w.Code != http.StatusMultiStatus {
+			t.Fatalf("PROPFIND %s returned %d instead of expected %d", p, w.Code, http.StatusMultiStatus)
+		}
+		return w.Body.String()
+	}
+
+	body := propfind("/folder", "1")
+	if !strings.Contains(body, "/remote.php/webdav/folder/visible") {
+		t.Errorf("listing does not contain the visible file: %s", body)
+	}
+	if strings.Contains(body, ".hidden") {
+		t.Errorf("listing contains the hidden file: %s", body)
+	}
+
+	body = propfind("/folder/.hidden", "0")
+	if !strings.Contains(body, "/remote.php/webdav/folder/.hidden") {
+		t.Errorf("hidden file cannot be accessed directly: %s", body)
+	}
+}
+
+func TestPropfindDefaultDepth(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		configured string
+		header     string
+		responses  int
+	}{
+		{"", "", 3},
+		{"0", "", 1},
+		{"0", "1", 3},
+	}
+	for _, tt := range tests {
+		g := &testGateway{
+			stat: func(req *provider.StatRequest) *provider.StatResponse {
+				return &provider.StatResponse{Status: status.NewOK(ctx), Info: &provider.ResourceInfo{
+					Type: provider.ResourceType_RESOURCE_TYPE_CONTAINER,
+					Path: req.Ref.GetPath(),
+				}}
+			},
+			listContainer: func(req *provider.ListContainerRequest) *provider.ListContainerResponse {
+				res := &provider.ListContainerResponse{Status: status.NewOK(ctx)}
+				for _, name := range []string{"a", "b"} {
+					res.Infos = append(res.Infos, &provider.ResourceInfo{
+						Type: provider.ResourceType_RESOURCE_TYPE_FILE,
+						Path: path.Join(req.Ref.GetPath(), name),
+					})
+				}
+				return res
+			},
+		}
+		s := newTestService(t, g, &Config{DefaultPropfindDepth: tt.configured})
+
+		r := httptest.NewRequest("PROPFIND", "/folder", nil)
+		if tt.header != "" {
+			r.Header.Set("Depth", tt.header)
+		}
+		r = r.WithContext(context.WithValue(r.Context(), ctxKeyBaseURI, "/remote.php/webdav"))
+		w := httptest.NewRecorder()
+		s.handlePropfind(w, r, "/home")
+
+		if w.Code != http.StatusMultiStatus {
+			t.Fatalf("PROPFIND returned %d instead of expected %d", w.Code, http.StatusMultiStatus)
+		}
+		if n := strings.Count(w.Body.String(), "<d:response>"); n != tt.responses {
+			t.Errorf("PROPFIND with default depth %q and Depth header %q returned %d responses instead of %d", tt.configured, tt.header, n, tt.responses)
+		}
+	}
+}
+
+func TestPublicFilePropfindDefaultDepth(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		configured string
+		responses  int
+	}{
+		{"0", 1},
+		{"1", 2},
+		// there is nothing below the shared file
+		{"infinity", 2},
+	}
+	for _, tt := range tests {
+		g := &testGateway{
+			getPath: func(req *provider.GetPathRequest) *provider.GetPathResponse {
+				return &provider.GetPathResponse{Status: status.NewOK(ctx), Path: "/home/file.txt"}
+			},
+		}
+		s := newTestService(t, g, &Config{DefaultPropfindDepth: tt.configured})
+
+		file := &provider.ResourceInfo{
+			Id:   &provider.ResourceId{StorageId: "storage", OpaqueId: "file"},
+			Type: provider.ResourceType_RESOURCE_TYPE_FILE,
+			Path: "/token",
+		}
+		r := httptest.NewRequest("PROPFIND", "/", nil)
+		rctx := context.WithValue(r.Context(), ctxKeyBaseURI, "/remote.php/dav/public-files")
+		r = r.WithContext(context.WithValue(rctx, tokenStatInfoKey{}, file))
+		w := httptest.NewRecorder()
+		s.handlePropfindOnToken(w, r, "/public", true)
+
+		if w.Code != http.StatusMultiStatus {
+			t.Fatalf("PROPFIND with default depth %q returned %d instead of expected %d", tt.configured, w.Code, http.StatusMultiStatus)
+		}
+		if n := strings.Count(w.Body.String(), "<d:response>"); n != tt.responses {
+			t.Errorf("PROPFIND with default depth %q returned %d responses instead of %d", tt.configured, n, tt.responses)
+		}
+	}
+}
+
+func contains(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+func TestApplyChildMtimes(t *testing.T) {
+	folder := &provider.ResourceInfo{
+		Type:  provider.ResourceType_RESOURCE_TYPE_CONTAINER,
+		Mtime: &typespb.Timestamp{Seconds: 1000},
+	}
+	children := []*provider.ResourceInfo{
+		{Type: provider.ResourceType_RESOURCE_TYPE_FILE, Mtime: &typespb.Timestamp{Seconds: 500}},
+		// a freshly uploaded file whose mtime has not yet been propagated
+		{Type: provider.ResourceType_RESOURCE_TYPE_FILE, Mtime: &typespb.Timestamp{Seconds: 2000, Nanos: 5}},
+		{Type: provider.ResourceType_RESOURCE_TYPE_FILE},
+	}
+
+	applyChildMtimes(folder, children)
+
+	if folder.Mtime.Seconds != 2000 || folder.Mtime.Nanos != 5 {
+		t.Errorf("folder mtime %v does not reflect the latest child mtime", folder.Mtime)
+	}
+
+	applyChildMtimes(folder, children[:1])
+	if folder.Mtime.Seconds != 2000 {
+		t.Errorf("folder mtime %v must not be set to an older child mtime", folder.Mtime)
+	}
+}
+
+func TestPutEmptyFile(t *testing.T) {
+	ctx := context.Background()
+	created := false
+	transfers := 0
+	dataServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		transfers++
+	}))
+	defer dataServer.Close()
+
+	g := &testGateway{
+		stat: func(req *provider.StatRequest) *provider.StatResponse {
+			if !created {
+				return &provider.StatResponse{Status: status.NewNotFound(ctx, "not found")}
+			}
+			return &provider.StatResponse{Status: status.NewOK(ctx), Info: &provider.ResourceInfo{
+				Id:       &provider.ResourceId{StorageId: "storage", OpaqueId: "empty"},
+				Type:     provider.ResourceType_RESOURCE_TYPE_FILE,
+				Path:     req.Ref.GetPath(),
+				Etag:     "\"etag\"",
+				MimeType: "text/plain",
+				Size:     0,
+				Mtime:    &typespb.Timestamp{Seconds: 1},
+			}}
+		},
+		initiateFileUpload: func(req *provider.InitiateFileUploadRequest) *gateway.InitiateFileUploadResponse {
+			created = true
+			if l := string(req.Opaque.Map["Upload-Length"].Value); l != "0" {
+				t.Errorf("upload was initiated with length %s instead of expected 0", l)
+			}
+			return &gateway.InitiateFileUploadResponse{
+				Status:    status.NewOK(ctx),
+				Protocols: []*gateway.FileUploadProtocol{{Protocol: "simple", UploadEndpoint: dataServer.URL}},
+			}
+		},
+	}
+	s := newTestService(t, g, &Config{})
+
+	r := httptest.NewRequest(http.MethodPut, "/empty.txt", nil)
+	r.Header.Set("Content-Length", "0")
+	w := httptest.NewRecorder()
+	s.handlePut(w, r, "/home")
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("PUT of an empty file returned %d instead of expected %d", w.Code, http.StatusCreated)
+	}
+	if transfers != 0 {
+		t.Errorf("PUT of an empty file transferred content %d times", transfers)
+	}
+	if etag := w.Header().Get("ETag"); etag != "\"etag\"" {
+		t.Errorf("unexpected ETag %s", etag)
+	}
+	if id := w.Header().Get("OC-FileId"); id != wrapResourceID(&provider.ResourceId{StorageId: "storage", OpaqueId: "empty"}) {
+		t.Errorf("unexpected OC-FileId %s", id)
+	}
+	if lm := w.Header().Get("Last-Modified"); lm == "" {
+		t.Error("missing Last-Modified header")
+	}
+}
+
+func TestTusPostForwardsUploadMetadata(t *testing.T) {
+	ctx := context.Background()
+	var opaque *typespb.Opaque
+	g := &testGateway{
+		stat: func(req *provider.StatRequest) *provider.StatResponse {
+			return &provider.StatResponse{Status: status.NewNotFound(ctx, "not found")}
+		},
+		initiateFileUpload: func(req *provider.InitiateFileUploadRequest) *gateway.InitiateFileUploadResponse {
+			opaque = req.Opaque
+			return &gateway.InitiateFileUploadResponse{
+				Status:    status.NewOK(ctx),
+				Protocols: []*gateway.FileUploadProtocol{{Protocol: "tus", UploadEndpoint: "http://localhost/data", Token: "token"}},
+			}
+		},
+	}
+	s := newTestService(t, g, &Config{UploadMetadataKeys: []string{"app"}})
+
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.Header.Set("Tus-Resumable", "1.0.0")
+	r.Header.Set("Upload-Length", "10")
+	r.Header.Set("Upload-Metadata", strings.Join([]string{
+		"filename " + base64.StdEncoding.EncodeToString([]byte("file.txt")),
+		"app " + base64.StdEncoding.EncodeToString([]byte("editor")),
+		"other " + base64.StdEncoding.EncodeToString([]byte("ignored")),
+	}, ","))
+	w := httptest.NewRecorder()
+	s.handleTusPost(w, r, "/home")
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("TUS POST returned %d instead of expected %d", w.Code, http.StatusCreated)
+	}
+	e := opaque.GetMap()["Upload-Metadata"]
+	if e == nil || e.Decoder != "json" {
+		t.Fatalf("expected json encoded upload metadata, got %+v", e)
+	}
+	if string(e.Value) != `{"http://owncloud.org/ns/app":"editor"}` {
+		t.Errorf("unexpected upload metadata %s", e.Value)
+	}
+}
+
+func TestUploadMetadataPropfindRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	stored := map[string]string{}
+	g := &testGateway{
+		stat: func(req *provider.StatRequest) *provider.StatResponse {
+			if len(stored) == 0 {
+				return &provider.StatResponse{Status: status.NewNotFound(ctx, "not found")}
+			}
+			md := map[string]string{}
+			for _, k := range req.ArbitraryMetadataKeys {
+				if v, ok := stored[k]; ok {
+					md[k] = v
+				}
+			}
+			return &provider.StatResponse{Status: status.NewOK(ctx), Info: &provider.ResourceInfo{
+				Type:              provider.ResourceType_RESOURCE_TYPE_FILE,
+				Path:              req.Ref.GetPath(),
+				ArbitraryMetadata: &provider.ArbitraryMetadata{Metadata: md},
+			}}
+		},
+		initiateFileUpload: func(req *provider.InitiateFileUploadRequest) *gateway.InitiateFileUploadResponse {
+			if e := req.Opaque.GetMap()["Upload-Metadata"]; e != nil {
+				if err := json.Unmarshal(e.Value, &stored); err != nil {
+					t.Errorf("could not unmarshal upload metadata: %v", err)
+				}
+			}
+			return &gateway.InitiateFileUploadResponse{
+				Status:    status.NewOK(ctx),
+				Protocols: []*gateway.FileUploadProtocol{{Protocol: "tus", UploadEndpoint: "http://localhost/data", Token: "token"}},
+			}
+		},
+	}
+	s := newTestService(t, g, &Config{UploadMetadataKeys: []string{"app"}})
+
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.Header.Set("Tus-Resumable", "1.0.0")
+	r.Header.Set("Upload-Length", "10")
+	r.Header.Set("Upload-Metadata", strings.Join([]string{
+		"filename " + base64.StdEncoding.EncodeToString([]byte("file.txt")),
+		"app " + base64.StdEncoding.EncodeToString([]byte("editor")),
+	}, ","))
+	w := httptest.NewRecorder()
+	s.handleTusPost(w, r, "/home")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("TUS POST returned %d instead of expected %d", w.Code, http.StatusCreated)
+	}
+
+	body := `<?xml version="1.0"?>
+<d:propfind xmlns:d="DAV:" xmlns:oc="http://owncloud.org/ns">
+  <d:prop><oc:app/><oc:other/></d:prop>
+</d:propfind>`
+	r = httptest.NewRequest("PROPFIND", "/file.txt", strings.NewReader(body))
+	r.Header.Set("Depth", "0")
+	r = r.WithContext(context.WithValue(r.Context(), ctxKeyBaseURI, "/remote.php/webdav"))
+	w = httptest.NewRecorder()
+	s.handlePropfind(w, r, "/home")
+
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("PROPFIND returned %d instead of expected %d", w.Code, http.StatusMultiStatus)
+	}
+	res := w.Body.String()
+	if !strings.Contains(res, "<oc:app>editor</oc:app>") {
+		t.Errorf("PROPFIND did not return the upload metadata: %s", res)
+	}
+	if strings.Contains(res, "<oc:other>") && !strings.Contains(res, "<oc:other></oc:other>") {
+		t.Errorf("PROPFIND returned a value for an unconfigured key: %s", res)
+	}
+}
+
+func TestGetFolderArchive(t *testing.T) {
+	ctx := context.Background()
+	dataServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("content of " + r.URL.Query().Get("file")))
+	}))
+	defer dataServer.Close()
+
+	g := &testGateway{
+		stat: func(req *provider.StatRequest) *provider.StatResponse {
+			return &provider.StatResponse{Status: status.NewOK(ctx), Info: &provider.ResourceInfo{
+				Type: provider.ResourceType_RESOURCE_TYPE_CONTAINER,
+				Path: req.Ref.GetPath(),
+			}}
+		},
+		listContainer: func(req *provider.ListContainerRequest) *provider.ListContainerResponse {
+			res := &provider.ListContainerResponse{Status: status.NewOK(ctx)}
+			if req.Ref.GetPath() == "/home/folder" {
+				for _, name := range []string{"a.txt", "b.txt", ".hidden.txt"} {
+					res.Infos = append(res.Infos, &provider.ResourceInfo{
+						Type: provider.ResourceType_RESOURCE_TYPE_FILE,
+						Path: path.Join(req.Ref.GetPath(), name),
+						Size: uint64(len("content of " + name)),
+					})
+				}
+			}
+			return res
+		},
+		initiateFileDownload: func(req *provider.InitiateFileDownloadRequest) *gateway.InitiateFileDownloadResponse {
+			return &gateway.InitiateFileDownloadResponse{
+				Status: status.NewOK(ctx),
+				Protocols: []*gateway.FileDownloadProtocol{{
+					Protocol:         "simple",
+					DownloadEndpoint: dataServer.URL + "?file=" + path.Base(req.Ref.GetPath()),
+				}},
+			}
+		},
+	}
+	s := newTestService(t, g, &Config{HiddenPatterns: []string{".*"}})
+
+	for _, format := range []string{"zip", "tar"} {
+		r := httptest.NewRequest(http.MethodGet, "/folder?format="+format, nil)
+		w := httptest.NewRecorder()
+		s.handleGet(w, r, "/home")
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET of the %s archive returned %d instead of expected %d", format, w.Code, http.StatusOK)
+		}
+
+		files := map[string]string{}
+		switch format {
+		case "zip":
+			zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+			if err != nil {
+				t.Fatalf("invalid zip archive: %v", err)
+			}
+			for _, f := range zr.File {
+				rc, err := f.Open()
+				if err != nil {
+					t.Fatalf("error opening %s: %v", f.Name, err)
+				}
+				b, _ := ioutil.ReadAll(rc)
+				rc.Close()
+				files[f.Name] = string(b)
+			}
+		case "tar":
+			tr := tar.NewReader(w.Body)
+			for {
+				h, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("invalid tar archive: %v", err)
+				}
+				b, _ := ioutil.ReadAll(tr)
+				files[h.Name] = string(b)
+			}
+		}
+
+		if len(files) != 2 || files["folder/a.txt"] != "content of a.txt" || files["folder/b.txt"] != "content of b.txt" {
+			t.Errorf("%s archive has unexpected content %v", format, files)
+		}
+	}
+}
+
+func TestArchiveFileWithoutSimpleProtocol(t *testing.T) {
+	ctx := context.Background()
+	g := &testGateway{
+		initiateFileDownload: func(req *provider.InitiateFileDownloadRequest) *gateway.InitiateFileDownloadResponse {
+			return &gateway.InitiateFileDownloadResponse{
+				Status: status.NewOK(ctx),
+				Protocols: []*gateway.FileDownloadProtocol{{
+					Protocol:         "spaces",
+					DownloadEndpoint: "http://localhost/data",
+				}},
+			}
+		},
+	}
+	s := newTestService(t, g, &Config{})
+	client, err := pool.GetGatewayServiceClient(s.c.GatewaySvc)
+	if err != nil {
+		t.Fatalf("error getting gateway client: %v", err)
+	}
+
+	aw := &zipArchive{w: zip.NewWriter(ioutil.Discard)}
+	err = s.archiveFile(ctx, client, aw, "folder/a.txt", &provider.ResourceInfo{Path: "/home/folder/a.txt"})
+	if err == nil || !strings.Contains(err.Error(), "no simple download protocol") {
+		t.Errorf("expected the missing simple protocol to be reported, got %v", err)
+	}
+}
+
+func TestPutStatCalls(t *testing.T) {
+	ctx := context.Background()
+	dataServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer dataServer.Close()
+
+	for _, exists := range []bool{false, true} {
+		stats := 0
+		uploaded := false
+		g := &testGateway{
+			stat: func(req *provider.StatRequest) *provider.StatResponse {
+				stats++
+				if !exists && !uploaded {
+					return &provider.StatResponse{Status: status.NewNotFound(ctx, "not found")}
+				}
+				return &provider.StatResponse{Status: status.NewOK(ctx), Info: &provider.ResourceInfo{
+					Id:    &provider.ResourceId{StorageId: "storage", OpaqueId: "file"},
+					Type:  provider.ResourceType_RESOURCE_TYPE_FILE,
+					Path:  req.Ref.GetPath(),
+					Etag:  "\"etag\"",
+					Mtime: &typespb.Timestamp{Seconds: 1},
+				}}
+			},
+			initiateFileUpload: func(req *provider.InitiateFileUploadRequest) *gateway.InitiateFileUploadResponse {
+				uploaded = true
+				return &gateway.InitiateFileUploadResponse{
+					Status:    status.NewOK(ctx),
+					Protocols: []*gateway.FileUploadProtocol{{Protocol: "simple", UploadEndpoint: dataServer.URL}},
+				}
+			},
+		}
+		s := newTestService(t, g, &Config{})
+
+		r := httptest.NewRequest(http.MethodPut, "/file.txt", strings.NewReader("data"))
+		r.Header.Set("Content-Length", "4")
+		w := httptest.NewRecorder()
+		s.handlePut(w, r, "/home")
+
+		if w.Code != http.StatusCreated && w.Code != http.StatusNoContent {
+			t.Fatalf("PUT returned unexpected status %d", w.Code)
+		}
+		// one stat to check the target and one to return the metadata of the uploaded file
+		if stats != 2 {
+			t.Errorf("PUT with existing target %t made %d stat calls instead of 2", exists, stats)
+		}
+	}
+}
+
+func TestPutUnsupportedChecksumAlgorithm(t *testing.T) {
+	ctx := context.Background()
+	g := &testGateway{
+		stat: func(req *provider.StatRequest) *provider.StatResponse {
+			return &provider.StatResponse{Status: status.NewNotFound(ctx, "not found")}
+		},
+		// the storage provider rejects the algorithm before creating an upload session
+		initiateFileUpload: func(req *provider.InitiateFileUploadRequest) *gateway.InitiateFileUploadResponse {
+			if xs := string(req.Opaque.Map["Upload-Checksum"].Value); !strings.HasPrefix(xs, "sha256 ") {
+				t.Errorf("unexpected Upload-Checksum %s", xs)
+			}
+			return &gateway.InitiateFileUploadResponse{
+				Status: status.NewInvalidArg(ctx, "The checksum algorithm SHA256 is not supported."),
+			}
+		},
+	}
+	s := newTestService(t, g, &Config{})
+
+	r := httptest.NewRequest(http.MethodPut, "/file.txt", strings.NewReader("hello"))
+	r.Header.Set("Content-Length", "5")
+	r.Header.Set("OC-Checksum", "SHA256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824")
+	w := httptest.NewRecorder()
+	s.handlePut(w, r, "/home")
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("PUT with unsupported checksum algorithm returned %d instead of expected %d", w.Code, http.StatusBadRequest)
+	}
+	if !strings.Contains(w.Body.String(), "SHA256") {
+		t.Errorf("error message does not mention the algorithm: %s", w.Body.String())
+	}
+}
+
+func TestPutSHA1ChecksumWithDefaultStorageConfig(t *testing.T) {
+	ctx := context.Background()
+	dataServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer dataServer.Close()
+
+	// a storage provider with the default available_checksums
+	sp, err := storageprovider.New(map[string]interface{}{
+		"driver":          "local",
+		"drivers":         map[string]interface{}{"local": map[string]interface{}{"root": t.TempDir()}},
+		"tmp_folder":      t.TempDir(),
+		"data_server_url": dataServer.URL,
+	}, nil)
+	if err != nil {
+		t.Fatalf("error creating storage provider: %v", err)
+	}
+	uploaded := false
+	g := &testGateway{
+		stat: func(req *provider.StatRequest) *provider.StatResponse {
+			if !uploaded {
+				return &provider.StatResponse{Status: status.NewNotFound(ctx, "not found")}
+			}
+			return &provider.StatResponse{Status: status.NewOK(ctx), Info: &provider.ResourceInfo{
+				Id:    &provider.ResourceId{StorageId: "storage", OpaqueId: "file"},
+				Type:  provider.ResourceType_RESOURCE_TYPE_FILE,
+				Path:  req.Ref.GetPath(),
+				Etag:  "\"etag\"",
+				Mtime: &typespb.Timestamp{Seconds: 1},
+			}}
+		},
+		initiateFileUpload: func(req *provider.InitiateFileUploadRequest) *gateway.InitiateFileUploadResponse {
+			uctx := user.ContextSetUser(ctx, &userpb.User{Id: &userpb.UserId{OpaqueId: "einstein"}, Username: "einstein"})
+			res, err := sp.(provider.ProviderAPIServer).InitiateFileUpload(uctx, req)
+			if err != nil {
+				t.Fatalf("InitiateFileUpload failed: %v", err)
+			}
+			gRes := &gateway.InitiateFileUploadResponse{Status: res.Status}
+			for _, p := range res.Protocols {
+				gRes.Protocols = append(gRes.Protocols, &gateway.FileUploadProtocol{Protocol: p.Protocol, UploadEndpoint: p.UploadEndpoint})
+			}
+			uploaded = res.Status.Code == rpc.Code_CODE_OK
+			return gRes
+		},
+	}
+	s := newTestService(t, g, &Config{})
+
+	// ownCloud clients send sha1 checksums, which are not listed in the default available_checksums
+	r := httptest.NewRequest(http.MethodPut, "/file.txt", strings.NewReader("hello"))
+	r.Header.Set("Content-Length", "5")
+	r.Header.Set("OC-Checksum", "SHA1:aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d")
+	w := httptest.NewRecorder()
+	s.handlePut(w, r, "/home")
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("PUT with a sha1 checksum returned %d instead of expected %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+}
+
+func TestMaxUploadSize(t *testing.T) {
+	// no gateway is needed, oversized uploads must be rejected before contacting it
+	s := &svc{c: &Config{MaxUploadSize: 10}}
+
+	r := httptest.NewRequest(http.MethodPut, "/big.bin", strings.NewReader("more than ten bytes"))
+	r.Header.Set("Content-Length", "19")
+	w := httptest.NewRecorder()
+	s.handlePut(w, r, "/home")
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("PUT over the max upload size returned %d instead of expected %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+
+	// every chunk fits, but the assembled file does not
+	r = httptest.NewRequest(http.MethodPut, "/big.bin-chunking-1234-2-0", strings.NewReader("ten bytes!"))
+	r.Header.Set("Content-Length", "10")
+	r.Header.Set("OC-Chunked", "1")
+	r.Header.Set("OC-Total-Length", "20")
+	w = httptest.NewRecorder()
+	s.handlePut(w, r, "/home")
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("chunked PUT over the max upload size returned %d instead of expected %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/", nil)
+	r.Header.Set("Tus-Resumable", "1.0.0")
+	r.Header.Set("Upload-Length", "11")
+	r.Header.Set("Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte("big.bin")))
+	w = httptest.NewRecorder()
+	s.handleTusPost(w, r, "/home")
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("TUS POST over the max upload size returned %d instead of expected %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestPublicFilePropfindDepth(t *testing.T) {
+	file := &provider.ResourceInfo{
+		Id:   &provider.ResourceId{StorageId: "storage", OpaqueId: "file"},
+		Type: provider.ResourceType_RESOURCE_TYPE_FILE,
+		Path: "/token/file.txt",
+	}
+	s := &svc{c: &Config{}}
+
+	r := httptest.NewRequest("PROPFIND", "/", nil)
+	r.Header.Set("Depth", "infinity")
+	r = r.WithContext(context.WithValue(r.Context(), tokenStatInfoKey{}, file))
+	w := httptest.NewRecorder()
+	s.handlePropfindOnToken(w, r, "/public", true)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("PROPFIND with Depth infinity returned %d instead of expected %d", w.Code, http.StatusForbidden)
+	}
+	if !strings.Contains(w.Body.String(), "propfind-finite-depth") {
+		t.Errorf("PROPFIND with Depth infinity did not report the propfind-finite-depth precondition: %s", w.Body.String())
+	}
+
+	// listing the virtual parent never goes beyond the shared file
+	if infos := s.getPublicFileInfos(true, false, file); len(infos) != 2 || infos[1] != file {
+		t.Errorf("Depth 1 listing of a public file link returned %v", infos)
+	}
+	if infos := s.getPublicFileInfos(true, true, file); len(infos) != 1 || infos[0].Type != provider.ResourceType_RESOURCE_TYPE_CONTAINER {
+		t.Errorf("Depth 0 listing of a public file link returned %v", infos)
+	}
+}
+
+func TestPropfindContainerETag(t *testing.T) {
+	ctx := context.Background()
+	g := &testGateway{
+		stat: func(req *provider.StatRequest) *provider.StatResponse {
+			return &provider.StatResponse{Status: status.NewOK(ctx), Info: &provider.ResourceInfo{
+				Id:    &provider.ResourceId{StorageId: "storage", OpaqueId: "folder"},
+				Type:  provider.ResourceType_RESOURCE_TYPE_CONTAINER,
+				Path:  req.Ref.GetPath(),
+				Etag:  "\"container-etag\"",
+				Mtime: &typespb.Timestamp{Seconds: 1},
+			}}
+		},
+	}
+	s := newTestService(t, g, &Config{})
+
+	r := httptest.NewRequest("PROPFIND", "/folder", nil)
+	r.Header.Set("Depth", "0")
+	r = r.WithContext(context.WithValue(r.Context(), ctxKeyBaseURI, "/remote.php/webdav"))
+	w := httptest.NewRecorder()
+	s.handlePropfind(w, r, "/home")
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("PROPFIND returned %d instead of expected %d", w.Code, http.StatusMultiStatus)
+	}
+	etag := w.Header().Get("ETag")
+	if etag != "\"container-etag\"" || w.Header().Get("OC-ETag") != etag {
+		t.Fatalf("PROPFIND returned ETag %s and OC-ETag %s", etag, w.Header().Get("OC-ETag"))
+	}
+
+	r = httptest.NewRequest("PROPFIND", "/folder", nil)
+	r.Header.Set("Depth", "0")
+	r.Header.Set("If-None-Match", etag)
+	r = r.WithContext(context.WithValue(r.Context(), ctxKeyBaseURI, "/remote.php/webdav"))
+	w = httptest.NewRecorder()
+	s.handlePropfind(w, r, "/home")
+	if w.Code != http.StatusNotModified {
+		t.Errorf("conditional PROPFIND returned %d instead of expected %d", w.Code, http.StatusNotModified)
+	}
+}
+
+func TestEtagMatches(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected bool
+	}{
+		{"", false},
+		{"\"etag\"", true},
+		{"W/\"etag\"", true},
+		{"\"other\", \"etag\"", true},
+		{"*", true},
+		{"\"other\"", false},
+	}
+	for _, tt := range tests {
+		if got := etagMatches(tt.header, "\"etag\""); got != tt.expected {
+			t.Errorf("etagMatches(%s) returned %t instead of expected %t", tt.header, got, tt.expected)
+		}
+	}
+}
+
+// treeClient serves a binary tree of containers with the given depth
+type treeClient struct {
+	gateway.GatewayAPIClient
+	depth  int
+	listed int
+	onList func()
+	denied string
+}
+
+func (c *treeClient) ListContainer(ctx context.Context, req *provider.ListContainerRequest, opts ...grpc.CallOption) (*provider.ListContainerResponse, error) {
+	c.listed++
+	if c.onList != nil {
+		c.onList()
+	}
+	if req.Ref.GetPath() == c.denied {
+		return &provider.ListContainerResponse{Status: status.NewPermissionDenied(ctx, nil, "denied")}, nil
+	}
+	res := &provider.ListContainerResponse{Status: status.NewOK(ctx)}
+	if strings.Count(req.Ref.GetPath(), "/") < c.depth {
+		for _, name := range []string{"a", "b"} {
+			res.Infos = append(res.Infos, &provider.ResourceInfo{
+				Type: provider.ResourceType_RESOURCE_TYPE_CONTAINER,
+				Path: path.Join(req.Ref.GetPath(), name),
+			})
+		}
+	}
+	return res, nil
+}
+
+func TestListDescendants(t *testing.T) {
+	c := &treeClient{depth: 4}
+	infos, st, err := listDescendants(context.Background(), c, "/root", nil, 0, nil)
+	if err != nil || st != nil {
+		t.Fatalf("error listing descendants: %v %v", err, st)
+	}
+	if len(infos) != 14 || c.listed != 15 {
+		t.Errorf("listed %d descendants with %d calls instead of 14 with 15 calls", len(infos), c.listed)
+	}
+}
+
+func TestListDescendantsStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := &treeClient{depth: 4, onList: cancel}
+
+	infos, _, err := listDescendants(ctx, c, "/root", nil, 0, nil)
+	if err != context.Canceled {
+		t.Errorf("expected the traversal to be cancelled, got %v", err)
+	}
+	if c.listed != 1 || len(infos) != 2 {
+		t.Errorf("traversal continued after cancellation: %d calls, %d descendants", c.listed, len(infos))
+	}
+}
+
+func TestListDescendantsSkipsDeniedContainers(t *testing.T) {
+	c := &treeClient{depth: 4, denied: "/root/a"}
+	infos, st, err := listDescendants(context.Background(), c, "/root", nil, 0, nil)
+	if err != nil || st != nil {
+		t.Fatalf("error listing descendants: %v %v", err, st)
+	}
+	if len(infos) != 8 || c.listed != 9 {
+		t.Errorf("listed %d descendants with %d calls instead of 8 with 9 calls", len(infos), c.listed)
+	}
+	for _, info := range infos {
+		if strings.HasPrefix(info.Path, "/root/a/") {
+			t.Errorf("listed %s below the denied container", info.Path)
+		}
+	}
+
+	c = &treeClient{depth: 4, denied: "/root"}
+	_, st, _ = listDescendants(context.Background(), c, "/root", nil, 0, nil)
+	if st.GetCode() != rpc.Code_CODE_PERMISSION_DENIED {
+		t.Errorf("expected the denied root to fail the listing, got %v", st)
+	}
+}
+
+func TestListDescendantsLimit(t *testing.T) {
+	c := &treeClient{depth: 4}
+	infos, _, err := listDescendants(context.Background(), c, "/root", nil, 3, nil)
+	if err != nil {
+		t.Fatalf("error listing descendants: %v", err)
+	}
+	if len(infos) != 4 || c.listed != 2 {
+		t.Errorf("listed %d descendants with %d calls instead of 4 with 2 calls", len(infos), c.listed)
+	}
+}
+
+func TestListDescendantsSkip(t *testing.T) {
+	c := &treeClient{depth: 4}
+	skip := func(info *provider.ResourceInfo) bool { return path.Base(info.Path) == "a" }
+	infos, st, err := listDescendants(context.Background(), c, "/root", nil, 0, skip)
+	if err != nil || st != nil {
+		t.Fatalf("error listing descendants: %v %v", err, st)
+	}
+	if len(infos) != 3 || c.listed != 4 {
+		t.Errorf("listed %d descendants with %d calls instead of 3 with 4 calls", len(infos), c.listed)
+	}
+	for _, info := range infos {
+		if strings.Contains(info.Path, "/a") {
+			t.Errorf("listed skipped resource %s", info.Path)
+		}
+	}
+}
+
+func TestPropfindTruncation(t *testing.T) {
+	ctx := context.Background()
+	tree := &treeClient{depth: 4}
+	g := &testGateway{
+		stat: func(req *provider.StatRequest) *provider.StatResponse {
+			return &provider.StatResponse{Status: status.NewOK(ctx), Info: &provider.ResourceInfo{
+				Type: provider.ResourceType_RESOURCE_TYPE_CONTAINER,
+				Path: req.Ref.GetPath(),
+			}}
+		},
+		listContainer: func(req *provider.ListContainerRequest) *provider.ListContainerResponse {
+			res, _ := tree.ListContainer(ctx, req)
+			return res
+		},
+	}
+	s := newTestService(t, g, &Config{MaxPropfindResults: 3})
+
+	r := httptest.NewRequest("PROPFIND", "/folder", nil)
+	r.Header.Set("Depth", "infinity")
+	r = r.WithContext(context.WithValue(r.Context(), ctxKeyBaseURI, "/remote.php/webdav"))
+	w := httptest.NewRecorder()
+	s.handlePropfind(w, r, "/home")
+
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("PROPFIND returned %d instead of expected %d", w.Code, http.StatusMultiStatus)
+	}
+	body := w.Body.String()
+	if n := strings.Count(body, "<d:response>"); n != 4 {
+		t.Errorf("PROPFIND returned %d responses instead of 3 resources and the truncation", n)
+	}
+	if !strings.Contains(body, "<d:href>/remote.php/webdav/folder/</d:href><d:status>HTTP/1.1 507 Insufficient Storage</d:status>") {
+		t.Errorf("PROPFIND response does not signal the truncation: %s", body)
+	}
+}
+
+func TestPropfindTruncationWithHiddenPatterns(t *testing.T) {
+	ctx := context.Background()
+	tree := &treeClient{depth: 4}
+	g := &testGateway{
+		stat: func(req *provider.StatRequest) *provider.StatResponse {
+			return &provider.StatResponse{Status: status.NewOK(ctx), Info: &provider.ResourceInfo{
+				Type: provider.ResourceType_RESOURCE_TYPE_CONTAINER,
+				Path: req.Ref.GetPath(),
+			}}
+		},
+		listContainer: func(req *provider.ListContainerRequest) *provider.ListContainerResponse {
+			res, _ := tree.ListContainer(ctx, req)
+			return res
+		},
+	}
+	// the hidden a subtrees are listed first, they must not use up the maximum number of results
+	s := newTestService(t, g, &Config{MaxPropfindResults: 2, HiddenPatterns: []string{"a"}})
+
+	r := httptest.NewRequest("PROPFIND", "/folder", nil)
+	r.Header.Set("Depth", "infinity")
+	r = r.WithContext(context.WithValue(r.Context(), ctxKeyBaseURI, "/remote.php/webdav"))
+	w := httptest.NewRecorder()
+	s.handlePropfind(w, r, "/home")
+
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("PROPFIND returned %d instead of expected %d", w.Code, http.StatusMultiStatus)
+	}
+	body := w.Body.String()
+	if strings.Contains(body, "/folder/a") {
+		t.Errorf("PROPFIND response contains hidden resources: %s", body)
+	}
+	if n := strings.Count(body, "<d:response>"); n != 3 {
+		t.Errorf("PROPFIND returned %d responses instead of 2 resources and the truncation", n)
+	}
+	if !strings.Contains(body, "507 Insufficient Storage") {
+		t.Errorf("PROPFIND response does not signal the truncation: %s", body)
+	}
+}
+
+func TestMkcolLastModified(t *testing.T) {
+	ctx := context.Background()
+	created := false
+	g := &testGateway{
+		stat: func(req *provider.StatRequest) *provider.StatResponse {
+			if !created {
+				return &provider.StatResponse{Status: status.NewNotFound(ctx, "not found")}
+			}
+			return &provider.StatResponse{Status: status.NewOK(ctx), Info: &provider.ResourceInfo{
+				Id:    &provider.ResourceId{StorageId: "storage", OpaqueId: "folder"},
+				Type:  provider.ResourceType_RESOURCE_TYPE_CONTAINER,
+				Path:  req.Ref.GetPath(),
+				Etag:  "\"etag\"",
+				Mtime: &typespb.Timestamp{Seconds: 1622505600},
+			}}
+		},
+		createContainer: func(req *provider.CreateContainerRequest) *provider.CreateContainerResponse {
+			created = true
+			return &provider.CreateContainerResponse{Status: status.NewOK(ctx)}
+		},
+	}
+	s := newTestService(t, g, &Config{})
+
+	r := httptest.NewRequest("MKCOL", "/folder", nil)
+	w := httptest.NewRecorder()
+	s.handleMkcol(w, r, "/home")
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("MKCOL returned %d instead of expected %d", w.Code, http.StatusCreated)
+	}
+	lm, err := time.Parse(time.RFC1123Z, w.Header().Get("Last-Modified"))
+	if err != nil {
+		t.Fatalf("MKCOL returned an invalid Last-Modified header: %v", err)
+	}
+	if lm.Unix() != 1622505600 {
+		t.Errorf("MKCOL returned Last-Modified %s instead of the container mtime", lm)
+	}
+	if w.Header().Get("ETag") != "\"etag\"" {
+		t.Errorf("MKCOL returned ETag %s", w.Header().Get("ETag"))
+	}
+}
+
+func TestCopyPreservesMtime(t *testing.T) {
+	ctx := context.Background()
+	dataServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			_, _ = w.Write([]byte("data"))
+		}
+	}))
+	defer dataServer.Close()
+
+	folder := &provider.ResourceInfo{
+		Type:  provider.ResourceType_RESOURCE_TYPE_CONTAINER,
+		Path:  "/home/folder",
+		Mtime: &typespb.Timestamp{Seconds: 1000},
+	}
+	file := &provider.ResourceInfo{
+		Type:  provider.ResourceType_RESOURCE_TYPE_FILE,
+		Path:  "/home/folder/file.txt",
+		Size:  4,
+		Mtime: &typespb.Timestamp{Seconds: 2000, Nanos: 5},
+	}
+
+	for _, preserve := range []bool{true, false} {
+		var fileMtime, folderMtime string
+		g := &testGateway{
+			stat: func(req *provider.StatRequest) *provider.StatResponse {
+				switch req.Ref.GetPath() {
+				case "/home":
+					return &provider.StatResponse{Status: status.NewOK(ctx), Info: &provider.ResourceInfo{Type: provider.ResourceType_RESOURCE_TYPE_CONTAINER}}
+				case folder.Path:
+					return &provider.StatResponse{Status: status.NewOK(ctx), Info: folder}
+				}
+				return &provider.StatResponse{Status: status.NewNotFound(ctx, "not found")}
+			},
+			createContainer: func(req *provider.CreateContainerRequest) *provider.CreateContainerResponse {
+				return &provider.CreateContainerResponse{Status: status.NewOK(ctx)}
+			},
+			listContainer: func(req *provider.ListContainerRequest) *provider.ListContainerResponse {
+				return &provider.ListContainerResponse{Status: status.NewOK(ctx), Infos: []*provider.ResourceInfo{file}}
+			},
+			initiateFileDownload: func(req *provider.InitiateFileDownloadRequest) *gateway.InitiateFileDownloadResponse {
+				return &gateway.InitiateFileDownloadResponse{
+					Status:    status.NewOK(ctx),
+					Protocols: []*gateway.FileDownloadProtocol{{Protocol: "simple", DownloadEndpoint: dataServer.URL}},
+				}
+			},
+			initiateFileUpload: func(req *provider.InitiateFileUploadRequest) *gateway.InitiateFileUploadResponse {
+				if e := req.Opaque.Map["X-OC-Mtime"]; e != nil {
+					fileMtime = string(e.Value)
+				}
+				return &gateway.InitiateFileUploadResponse{
+					Status:    status.NewOK(ctx),
+					Protocols: []*gateway.FileUploadProtocol{{Protocol: "simple", UploadEndpoint: dataServer.URL}},
+				}
+			},
+			setArbitraryMetadata: func(req *provider.SetArbitraryMetadataRequest) *provider.SetArbitraryMetadataResponse {
+				if req.Ref.GetPath() == "/home/copy" {
+					folderMtime = req.ArbitraryMetadata.Metadata["mtime"]
+				}
+				return &provider.SetArbitraryMetadataResponse{Status: status.NewOK(ctx)}
+			},
+		}
+		s := newTestService(t, g, &Config{PreserveCopyMtime: preserve})
+
+		r := httptest.NewRequest("COPY", "/folder", nil)
+		r.Header.Set("Destination", "http://localhost/remote.php/webdav/copy")
+		r = r.WithContext(context.WithValue(r.Context(), ctxKeyBaseURI, "/remote.php/webdav"))
+		w := httptest.NewRecorder()
+		s.handleCopy(w, r, "/home")
+
+		if w.Code != http.StatusCreated {
+			t.Fatalf("COPY returned %d instead of expected %d", w.Code, http.StatusCreated)
+		}
+		if preserve && (fileMtime != "2000.000000005" || folderMtime != "1000.000000000") {
+			t.Errorf("COPY did not preserve the mtimes, file: %q, folder: %q", fileMtime, folderMtime)
+		}
+		if !preserve && (fileMtime != "" || folderMtime != "") {
+			t.Errorf("COPY preserved the mtimes, file: %q, folder: %q", fileMtime, folderMtime)
+		}
+	}
+}
+
+func TestCopyRetriesTransientErrors(t *testing.T) {
+	ctx := context.Background()
+	downloads, initiated := 0, 0
+	var uploadTokens []string
+	dataServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			downloads++
+			if downloads == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			_, _ = w.Write([]byte("data"))
+		case http.MethodPut:
+			uploadTokens = append(uploadTokens, r.Header.Get(datagateway.TokenTransportHeader))
+			if len(uploadTokens) == 1 {
+				w.WriteHeader(http.StatusBadGateway)
+			}
+		}
+	}))
+	defer dataServer.Close()
+
+	file := &provider.ResourceInfo{
+		Type: provider.ResourceType_RESOURCE_TYPE_FILE,
+		Path: "/home/file.txt",
+		Size: 4,
+	}
+	g := &testGateway{
+		stat: func(req *provider.StatRequest) *provider.StatResponse {
+			switch req.Ref.GetPath() {
+			case "/home":
+				return &provider.StatResponse{Status: status.NewOK(ctx), Info: &provider.ResourceInfo{Type: provider.ResourceType_RESOURCE_TYPE_CONTAINER}}
+			case file.Path:
+				return &provider.StatResponse{Status: status.NewOK(ctx), Info: file}
+			}
+			return &provider.StatResponse{Status: status.NewNotFound(ctx, "not found")}
+		},
+		initiateFileDownload: func(req *provider.InitiateFileDownloadRequest) *gateway.InitiateFileDownloadResponse {
+			return &gateway.InitiateFileDownloadResponse{
+				Status:    status.NewOK(ctx),
+				Protocols: []*gateway.FileDownloadProtocol{{Protocol: "simple", DownloadEndpoint: dataServer.URL}},
+			}
+		},
+		initiateFileUpload: func(req *provider.InitiateFileUploadRequest) *gateway.InitiateFileUploadResponse {
+			initiated++
+			return &gateway.InitiateFileUploadResponse{
+				Status:    status.NewOK(ctx),
+				Protocols: []*gateway.FileUploadProtocol{{Protocol: "simple", UploadEndpoint: dataServer.URL, Token: fmt.Sprintf("upload-%d", initiated)}},
+			}
+		},
+	}
+	s := newTestService(t, g, &Config{})
+
+	r := httptest.NewRequest("COPY", "/file.txt", nil)
+	r.Header.Set("Destination", "http://localhost/remote.php/webdav/copy.txt")
+	r = r.WithContext(context.WithValue(r.Context(), ctxKeyBaseURI, "/remote.php/webdav"))
+	w := httptest.NewRecorder()
+	s.handleCopy(w, r, "/home")
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("COPY returned %d instead of expected %d", w.Code, http.StatusCreated)
+	}
+	if downloads != 3 || initiated != 3 {
+		t.Errorf("COPY downloaded %d and initiated %d uploads instead of 3 and 3", downloads, initiated)
+	}
+	// every attempt has to use the upload it initiated
+	if strings.Join(uploadTokens, " ") != "upload-2 upload-3" {
+		t.Errorf("COPY uploaded with the tokens %v instead of upload-2 and upload-3", uploadTokens)
+	}
+
+	if !isTransientStatus(http.StatusBadGateway) || isTransientStatus(http.StatusForbidden) {
+		t.Errorf("only gateway errors must be retried")
+	}
+}
+
+func TestCopyExceedingQuota(t *testing.T) {
+	ctx := context.Background()
+	created := false
+	folder := &provider.ResourceInfo{
+		Type: provider.ResourceType_RESOURCE_TYPE_CONTAINER,
+		Path: "/home/folder",
+		Size: 100,
+	}
+	g := &testGateway{
+		stat: func(req *provider.StatRequest) *provider.StatResponse {
+			switch req.Ref.GetPath() {
+			case "/home":
+				return &provider.StatResponse{Status: status.NewOK(ctx), Info: &provider.ResourceInfo{Type: provider.ResourceType_RESOURCE_TYPE_CONTAINER}}
+			case folder.Path:
+				return &provider.StatResponse{Status: status.NewOK(ctx), Info: folder}
+			}
+			return &provider.StatResponse{Status: status.NewNotFound(ctx, "not found")}
+		},
+		getQuota: func(req *gateway.GetQuotaRequest) *provider.GetQuotaResponse {
+			return &provider.GetQuotaResponse{Status: status.NewOK(ctx), TotalBytes: 150, UsedBytes: 60}
+		},
+		createContainer: func(req *provider.CreateContainerRequest) *provider.CreateContainerResponse {
+			created = true
+			return &provider.CreateContainerResponse{Status: status.NewOK(ctx)}
+		},
+	}
+	s := newTestService(t, g, &Config{})
+
+	r := httptest.NewRequest("COPY", "/folder", nil)
+	r.Header.Set("Destination", "http://localhost/remote.php/webdav/copy")
+	r = r.WithContext(context.WithValue(r.Context(), ctxKeyBaseURI, "/remote.php/webdav"))
+	w := httptest.NewRecorder()
+	s.handleCopy(w, r, "/home")
+
+	if w.Code != http.StatusInsufficientStorage {
+		t.Fatalf("COPY returned %d instead of expected %d", w.Code, http.StatusInsufficientStorage)
+	}
+	if created {
+		t.Errorf("COPY started copying although the quota is exceeded")
+	}
+}
+
+// quotaClient reports a quota of 150 bytes with 60 bytes used and lists the files in children
+type quotaClient struct {
+	gateway.GatewayAPIClient
+	children map[string][]*provider.ResourceInfo
+}
+
+func (c *quotaClient) GetQuota(ctx context.Context, req *gateway.GetQuotaRequest, opts ...grpc.CallOption) (*provider.GetQuotaResponse, error) {
+	return &provider.GetQuotaResponse{Status: status.NewOK(ctx), TotalBytes: 150, UsedBytes: 60}, nil
+}
+
+func (c *quotaClient) ListContainer(ctx context.Context, req *provider.ListContainerRequest, opts ...grpc.CallOption) (*provider.ListContainerResponse, error) {
+	return &provider.ListContainerResponse{Status: status.NewOK(ctx), Infos: c.children[req.Ref.GetPath()]}, nil
+}
+
+func TestExceedsQuota(t *testing.T) {
+	file := func(p string, size uint64) *provider.ResourceInfo {
+		return &provider.ResourceInfo{Type: provider.ResourceType_RESOURCE_TYPE_FILE, Path: p, Size: size}
+	}
+	folder := func(p string) *provider.ResourceInfo {
+		return &provider.ResourceInfo{Type: provider.ResourceType_RESOURCE_TYPE_CONTAINER, Path: p}
+	}
+	// neither folder reports a tree size, the source holds 100 bytes and the old destination 50 bytes
+	c := &quotaClient{children: map[string][]*provider.ResourceInfo{
+		"/home/src":     {file("/home/src/a", 40), folder("/home/src/sub")},
+		"/home/src/sub": {file("/home/src/sub/b", 60)},
+		"/home/dst":     {file("/home/dst/c", 50)},
+	}}
+
+	tests := []struct {
+		name        string
+		src         *provider.ResourceInfo
+		recurse     bool
+		overwritten *provider.ResourceInfo
+		expected    bool
+	}{
+		{"file", file("/home/a", 100), true, nil, true},
+		{"folder without tree size", folder("/home/src"), true, nil, true},
+		{"folder with tree size", &provider.ResourceInfo{Type: provider.ResourceType_RESOURCE_TYPE_CONTAINER, Path: "/home/src", Size: 80}, true, nil, false},
+		{"folder without recursion", folder("/home/src"), false, nil, false},
+		{"overwritten file", file("/home/a", 100), true, file("/home/b", 10), false},
+		{"overwritten folder", folder("/home/src"), true, folder("/home/dst"), false},
+	}
+	for _, tt := range tests {
+		exceeded, err := exceedsQuota(context.Background(), c, tt.src, tt.recurse, tt.overwritten, "/home")
+		if err != nil {
+			t.Fatalf("%s: error checking quota: %v", tt.name, err)
+		}
+		if exceeded != tt.expected {
+			t.Errorf("%s: quota exceeded is %t instead of %t", tt.name, exceeded, tt.expected)
+		}
+	}
+}
+
+func TestFilesRoot(t *testing.T) {
+	tests := []struct {
+		method  string
+		message string
+	}{
+		{"PROPFIND", "Listing members of this collection is disabled"},
+		{"PUT", "PUT is not allowed on this collection, use the files collection of a user"},
+	}
+	s := newTestService(t, &testGateway{}, &Config{})
+	h := new(DavHandler)
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "/files", nil)
+		w := httptest.NewRecorder()
+		h.Handler(s).ServeHTTP(w, r)
+
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s returned %d instead of expected %d", tt.method, w.Code, http.StatusMethodNotAllowed)
+		}
+		if !strings.Contains(w.Body.String(), "<s:message>"+tt.message+"</s:message>") {
+			t.Errorf("%s returned unexpected body %s", tt.method, w.Body.String())
+		}
+	}
+}
+
+func TestIconClass(t *testing.T) {
+	c := &Config{}
+	c.init()
+	s := &svc{c: c}
+
+	tests := []struct {
+		mimeType string
+		expected string
+	}{
+		{"httpd/unix-directory", "icon-folder"},
+		{"application/pdf", "icon-pdf"},
+		{"image/png", "icon-image"},
+		{"text/plain", "icon-text"},
+		{"application/octet-stream", "icon-file"},
+		{"", "icon-file"},
+	}
+	for _, tt := range tests {
+		if got := s.iconClass(tt.mimeType); got != tt.expected {
+			t.Errorf("iconClass(%s) returned %s instead of expected %s", tt.mimeType, got, tt.expected)
+		}
+	}
+
+	ctx := context.WithValue(context.Background(), ctxKeyBaseURI, "/remote.php/webdav")
+	pf := &propfindXML{Prop: propfindProps{{Space: _nsOwncloud, Local: "icon-class"}}}
+	md := &provider.ResourceInfo{
+		Type:     provider.ResourceType_RESOURCE_TYPE_FILE,
+		Path:     "/home/photo.jpg",
+		MimeType: "image/jpeg",
+	}
+	res, err := s.mdToPropResponse(ctx, pf, md, "/home")
+	if err != nil {
+		t.Fatalf("mdToPropResponse failed: %v", err)
+	}
+	if len(res.Propstat) == 0 || len(res.Propstat[0].Prop) != 1 ||
+		res.Propstat[0].Prop[0].XMLName.Local != "oc:icon-class" ||
+		string(res.Propstat[0].Prop[0].InnerXML) != "icon-image" {
+		t.Errorf("unexpected icon class propstat %+v", res.Propstat)
+	}
+}
+
+func TestHasPreview(t *testing.T) {
+	c := &Config{}
+	c.init()
+	s := &svc{c: c}
+
+	tests := []struct {
+		md       *provider.ResourceInfo
+		expected string
+	}{
+		{&provider.ResourceInfo{Type: provider.ResourceType_RESOURCE_TYPE_FILE, Path: "/home/photo.png", MimeType: "image/png"}, "true"},
+		{&provider.ResourceInfo{Type: provider.ResourceType_RESOURCE_TYPE_FILE, Path: "/home/archive.zip", MimeType: "application/zip"}, "false"},
+		{&provider.ResourceInfo{Type: provider.ResourceType_RESOURCE_TYPE_CONTAINER, Path: "/home/folder", MimeType: "httpd/unix-directory"}, "false"},
+	}
+
+	ctx := context.WithValue(context.Background(), ctxKeyBaseURI, "/remote.php/webdav")
+	pf := &propfindXML{Prop: propfindProps{{Space: _nsOwncloud, Local: "has-preview"}}}
+	for _, tt := range tests {
+		res, err := s.mdToPropResponse(ctx, pf, tt.md, "/home")
+		if err != nil {
+			t.Fatalf("mdToPropResponse failed: %v", err)
+		}
+		if len(res.Propstat) == 0 || len(res.Propstat[0].Prop) != 1 ||
+			string(res.Propstat[0].Prop[0].InnerXML) != tt.expected {
+			t.Errorf("unexpected has-preview propstat for %s: %+v", tt.md.MimeType, res.Propstat)
+		}
+	}
+
+	s.c.PreviewMimeTypes = []string{"application/"}
+	if !s.hasPreview(&provider.ResourceInfo{Type: provider.ResourceType_RESOURCE_TYPE_FILE, MimeType: "application/zip"}) {
+		t.Error("major type entries should match all mimetypes of that type")
+	}
+}
+
+func TestPutIfUnmodifiedSince(t *testing.T) {
+	ctx := context.Background()
+	mtime := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	initiated := false
+	g := &testGateway{
+		stat: func(req *provider.StatRequest) *provider.StatResponse {
+			return &provider.StatResponse{Status: status.NewOK(ctx), Info: &provider.ResourceInfo{
+				Id:    &provider.ResourceId{StorageId: "storage", OpaqueId: "file"},
+				Type:  provider.ResourceType_RESOURCE_TYPE_FILE,
+				Path:  req.Ref.GetPath(),
+				Etag:  "\"etag\"",
+				Mtime: &typespb.Timestamp{Seconds: uint64(mtime.Unix())},
+			}}
+		},
+		initiateFileUpload: func(req *provider.InitiateFileUploadRequest) *gateway.InitiateFileUploadResponse {
+			initiated = true
+			return &gateway.InitiateFileUploadResponse{Status: status.NewInternal(ctx, errors.New("unexpected"), "unexpected")}
+		},
+	}
+	s := newTestService(t, g, &Config{})
+
+	r := httptest.NewRequest(http.MethodPut, "/file.txt", strings.NewReader("hello"))
+	r.Header.Set("Content-Length", "5")
+	r.Header.Set("If-Unmodified-Since", mtime.Add(-time.Hour).Format(http.TimeFormat))
+	w := httptest.NewRecorder()
+	s.handlePut(w, r, "/home")
+
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("PUT with a stale If-Unmodified-Since returned %d instead of expected %d", w.Code, http.StatusPreconditionFailed)
+	}
+	if initiated {
+		t.Error("PUT with a stale If-Unmodified-Since initiated an upload")
+	}
+
+	info := &provider.ResourceInfo{Mtime: &typespb.Timestamp{Seconds: uint64(mtime.Unix()), Nanos: 500}}
+	if modifiedSince(mtime.Format(http.TimeFormat), info) {
+		t.Error("a resource modified within the same second must not be considered modified")
+	}
+	if modifiedSince("not a date", info) {
+		t.Error("invalid dates must be ignored")
+	}
+}
@@ -34,15 +34,17 @@ import (
 
 	"go.opencensus.io/trace"
 
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
 	userv1beta1 "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
 	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
 	link "github.com/cs3org/go-cs3apis/cs3/sharing/link/v1beta1"
 	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
 	"github.com/cs3org/reva/internal/grpc/services/storageprovider"
-	"github.com/cs3org/reva/internal/http/services/owncloud/ocs/conversions"
 	"github.com/cs3org/reva/pkg/appctx"
+	"github.com/cs3org/reva/pkg/conversions"
 	ctxuser "github.com/cs3org/reva/pkg/user"
 	"github.com/cs3org/reva/pkg/utils"
+	"github.com/pkg/errors"
 )
 
 const (
@@ -69,7 +71,7 @@ func (s *svc) handlePropfind(w http.ResponseWriter, r *http.Request, ns string)
 	fn := path.Join(ns, r.URL.Path)
 	depth := r.Header.Get("Depth")
 	if depth == "" {
-		depth = "1"
+		depth = s.c.DefaultPropfindDepth
 	}
 
 	sublog := appctx.GetLogger(ctx).With().Str("path", fn).Logger()
@@ -95,21 +97,7 @@ func (s *svc) handlePropfind(w http.ResponseWriter, r *http.Request, ns string)
 		return
 	}
 
-	metadataKeys := []string{}
-	if pf.Allprop != nil {
-		// TODO this changes the behavior and returns all properties if allprops has been set,
-		// but allprops should only return some default properties
-		// see https://tools.ietf.org/html/rfc4918#section-9.1
-		// the description of arbitrary_metadata_keys in https://cs3org.github.io/cs3apis/#cs3.storage.provider.v1beta1.ListContainerRequest an others may need clarification
-		// tracked in https://github.com/cs3org/cs3apis/issues/104
-		metadataKeys = append(metadataKeys, "*")
-	} else {
-		for i := range pf.Prop {
-			if requiresExplicitFetching(&pf.Prop[i]) {
-				metadataKeys = append(metadataKeys, metadataKeyOf(&pf.Prop[i]))
-			}
-		}
-	}
+	metadataKeys := s.metadataKeys(&pf)
 	ref := &provider.Reference{
 		Spec: &provider.Reference_Path{Path: fn},
 	}
@@ -130,6 +118,20 @@ func (s *svc) handlePropfind(w http.ResponseWriter, r *http.Request, ns string)
 	}
 
 	info := res.Info
+
+	// the etag of a container changes when its children change, so clients can cache finite depth listings.
+	// Favorites and shares are not part of the etag, so a 304 does not tell the client whether oc:favorite
+	// or oc:share-types of the container or its children have changed.
+	if info.Type == provider.ResourceType_RESOURCE_TYPE_CONTAINER && depth != "infinity" && info.Etag != "" {
+		w.Header().Set("ETag", info.Etag)
+		w.Header().Set("OC-ETag", info.Etag)
+		if etagMatches(r.Header.Get("If-None-Match"), info.Etag) {
+			sublog.Debug().Str("etag", info.Etag).Msg("container not modified")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
 	infos := []*provider.ResourceInfo{info}
 	if info.Type == provider.ResourceType_RESOURCE_TYPE_CONTAINER && depth == "1" {
 		req := &provider.ListContainerRequest{
@@ -147,54 +149,47 @@ func (s *svc) handlePropfind(w http.ResponseWriter, r *http.Request, ns string)
 			HandleErrorStatus(&sublog, w, res.Status)
 			return
 		}
-		infos = append(infos, res.Infos...)
+		if s.c.EffectiveContainerMtime {
+			applyChildMtimes(info, res.Infos)
+		}
+		infos = append(infos, s.filterHidden(info.Path, res.Infos)...)
 	} else if depth == "infinity" {
-		// FIXME: doesn't work cross-storage as the results will have the wrong paths!
-		// use a stack to explore sub-containers breadth-first
-		stack := []string{info.Path}
-		for len(stack) > 0 {
-			// retrieve path on top of stack
-			path := stack[len(stack)-1]
-			ref = &provider.Reference{
-				Spec: &provider.Reference_Path{Path: path},
-			}
-			req := &provider.ListContainerRequest{
-				Ref:                   ref,
-				ArbitraryMetadataKeys: metadataKeys,
-			}
-			res, err := client.ListContainer(ctx, req)
-			if err != nil {
-				sublog.Error().Err(err).Str("path", path).Msg("error sending list container grpc request")
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
-			if res.Status.Code != rpc.Code_CODE_OK {
-				HandleErrorStatus(&sublog, w, res.Status)
-				return
-			}
-
-			infos = append(infos, res.Infos...)
-
-			if depth != "infinity" {
-				break
-			}
-
-			// TODO: stream response to avoid storing too many results in memory
-
-			stack = stack[:len(stack)-1]
-
-			// check sub-containers in reverse order and add them to the stack
-			// the reversed order here will produce a more logical sorting of results
-			for i := len(res.Infos) - 1; i >= 0; i-- {
-				// for i := range res.Infos {
-				if res.Infos[i].Type == provider.ResourceType_RESOURCE_TYPE_CONTAINER {
-					stack = append(stack, res.Infos[i].Path)
-				}
-			}
+		// hidden resources are skipped while traversing, so they do not count towards the maximum number of results
+		descendants, st, err := listDescendants(ctx, client, info.Path, metadataKeys, s.c.MaxPropfindResults, s.hiddenBelow(info.Path))
+		switch {
+		case ctx.Err() != nil:
+			// the client is gone, there is no one to respond to
+			sublog.Debug().Err(ctx.Err()).Int("listed", len(descendants)).Msg("propfind cancelled, aborting traversal")
+			return
+		case err != nil:
+			sublog.Error().Err(err).Msg("error listing descendants")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		case st != nil:
+			HandleErrorStatus(&sublog, w, st)
+			return
 		}
+		infos = append(infos, descendants...)
+	}
+
+	// the href has to be determined before the namespace is trimmed from the path when formatting
+	href := path.Join(ctx.Value(ctxKeyBaseURI).(string), strings.TrimPrefix(info.Path, ns))
+	truncated := s.c.MaxPropfindResults > 0 && len(infos) > s.c.MaxPropfindResults
+	if truncated {
+		sublog.Debug().Int("max", s.c.MaxPropfindResults).Msg("truncating propfind response")
+		infos = infos[:s.c.MaxPropfindResults]
 	}
 
-	propRes, err := s.formatPropfind(ctx, &pf, infos, ns)
+	responses, err := s.propfindResponses(ctx, &pf, infos, ns)
+	if err != nil {
+		sublog.Error().Err(err).Msg("error formatting propfind")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if truncated {
+		responses = append(responses, truncatedResponse(href, s.c.MaxPropfindResults))
+	}
+	propRes, err := multistatus(responses)
 	if err != nil {
 		sublog.Error().Err(err).Msg("error formatting propfind")
 		w.WriteHeader(http.StatusInternalServerError)
@@ -222,6 +217,208 @@ func (s *svc) handlePropfind(w http.ResponseWriter, r *http.Request, ns string)
 	}
 }
 
+// listDescendants lists all resources below the container at p. It stops as soon as the context
+// is cancelled, so a disconnected client does not keep the server busy with a huge traversal.
+// When limit is greater than 0 the traversal also stops once more than limit resources have been listed.
+// Resources for which skip returns true are left out together with their descendants, skip may be nil.
+// Sub-containers the user is not allowed to list are skipped, any other non OK status of a ListContainer call
+// is returned as is.
+func listDescendants(ctx context.Context, client gateway.GatewayAPIClient, p string, metadataKeys []string, limit int, skip func(*provider.ResourceInfo) bool) ([]*provider.ResourceInfo, *rpc.Status, error) {
+	infos := []*provider.ResourceInfo{}
+	// FIXME: doesn't work cross-storage as the results will have the wrong paths!
+	// use a stack to explore sub-containers breadth-first
+	stack := []string{p}
+	for len(stack) > 0 {
+		if err := ctx.Err(); err != nil {
+			return infos, nil, err
+		}
+		// retrieve path on top of stack
+		path := stack[len(stack)-1]
+		req := &provider.ListContainerRequest{
+			Ref: &provider.Reference{
+				Spec: &provider.Reference_Path{Path: path},
+			},
+			ArbitraryMetadataKeys: metadataKeys,
+		}
+		res, err := client.ListContainer(ctx, req)
+		if err != nil {
+			return infos, nil, errors.Wrap(err, "error sending list container grpc request for "+path)
+		}
+		if res.Status.Code == rpc.Code_CODE_PERMISSION_DENIED && path != p {
+			// a single inaccessible subtree should not fail the whole listing
+			appctx.GetLogger(ctx).Debug().Str("path", path).Msg("skipping container that cannot be listed")
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		if res.Status.Code != rpc.Code_CODE_OK {
+			return infos, res.Status, nil
+		}
+
+		children := make([]*provider.ResourceInfo, 0, len(res.Infos))
+		for _, info := range res.Infos {
+			if skip == nil || !skip(info) {
+				children = append(children, info)
+			}
+		}
+		infos = append(infos, children...)
+		if limit > 0 && len(infos) > limit {
+			return infos, nil, nil
+		}
+
+		// TODO: stream response to avoid storing too many results in memory
+
+		stack = stack[:len(stack)-1]
+
+		// check sub-containers in reverse order and add them to the stack
+		// the reversed order here will produce a more logical sorting of results
+		for i := len(children) - 1; i >= 0; i-- {
+			if children[i].Type == provider.ResourceType_RESOURCE_TYPE_CONTAINER {
+				stack = append(stack, children[i].Path)
+			}
+		}
+	}
+	return infos, nil, nil
+}
+
+// filterHidden removes the resources below the listed container at p whose name or the name of one of their
+// parents below p matches a hidden pattern. The container itself is always kept.
+func (s *svc) filterHidden(p string, infos []*provider.ResourceInfo) []*provider.ResourceInfo {
+	hidden := s.hiddenBelow(p)
+	filtered := infos[:0]
+	for _, info := range infos {
+		if !hidden(info) {
+			filtered = append(filtered, info)
+		}
+	}
+	return filtered
+}
+
+// hiddenBelow returns a function telling if a resource below the container at p is hidden
+func (s *svc) hiddenBelow(p string) func(*provider.ResourceInfo) bool {
+	return func(info *provider.ResourceInfo) bool {
+		return s.isHidden(strings.TrimPrefix(info.Path, p))
+	}
+}
+
+// isHidden checks if one of the segments of the relative path rel matches a hidden pattern
+func (s *svc) isHidden(rel string) bool {
+	for _, segment := range strings.Split(strings.Trim(rel, "/"), "/") {
+		for _, pattern := range s.c.HiddenPatterns {
+			if ok, _ := path.Match(pattern, segment); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// applyChildMtimes sets the mtime of the container to the latest mtime of its children
+// if one of them has been modified after the container
+func applyChildMtimes(container *provider.ResourceInfo, children []*provider.ResourceInfo) {
+	for _, child := range children {
+		if child.Mtime == nil {
+			continue
+		}
+		if container.Mtime == nil || utils.TSToTime(child.Mtime).After(utils.TSToTime(container.Mtime)) {
+			container.Mtime = child.Mtime
+		}
+	}
+}
+
+// hasPreview checks if previews can be generated for the mimetype of a file
+func (s *svc) hasPreview(md *provider.ResourceInfo) bool {
+	if md.Type != provider.ResourceType_RESOURCE_TYPE_FILE {
+		return false
+	}
+	majorType := md.MimeType
+	if i := strings.Index(md.MimeType, "/"); i > 0 {
+		majorType = md.MimeType[:i+1]
+	}
+	for _, t := range s.c.PreviewMimeTypes {
+		if t == md.MimeType || t == majorType {
+			return true
+		}
+	}
+	return false
+}
+
+// metadataKeys returns the arbitrary metadata keys that need to be fetched for the given propfind.
+// The keys are computed once per request and shared by all stat and list calls, each key is only requested once.
+func (s *svc) metadataKeys(pf *propfindXML) []string {
+	metadataKeys := []string{}
+	seen := map[string]bool{}
+	add := func(k string) {
+		if !seen[k] {
+			seen[k] = true
+			metadataKeys = append(metadataKeys, k)
+		}
+	}
+	if pf.Allprop != nil {
+		// allprop should only return some default properties
+		// see https://tools.ietf.org/html/rfc4918#section-9.1
+		// the description of arbitrary_metadata_keys in https://cs3org.github.io/cs3apis/#cs3.storage.provider.v1beta1.ListContainerRequest an others may need clarification
+		// tracked in https://github.com/cs3org/cs3apis/issues/104
+		if s.c.AllpropIncludeAllMetadata {
+			return append(metadataKeys, "*")
+		}
+		for _, k := range s.c.AllpropMetadataKeys {
+			add(k)
+		}
+		// clients can ask for additional properties using the DAV:include element
+		for i := range pf.Include {
+			if requiresExplicitFetching(&pf.Include[i]) || s.isUploadMetadataKey(&pf.Include[i]) {
+				add(metadataKeyOf(&pf.Include[i]))
+			}
+		}
+	} else {
+		for i := range pf.Prop {
+			if requiresExplicitFetching(&pf.Prop[i]) || s.isUploadMetadataKey(&pf.Prop[i]) {
+				add(metadataKeyOf(&pf.Prop[i]))
+			}
+		}
+	}
+	return metadataKeys
+}
+
+// isUploadMetadataKey checks if the property is one of the configured upload metadata keys, which are
+// stored as arbitrary metadata in the owncloud namespace
+func (s *svc) isUploadMetadataKey(n *xml.Name) bool {
+	if n.Space != _nsOwncloud {
+		return false
+	}
+	for _, k := range s.c.UploadMetadataKeys {
+		if k == n.Local {
+			return true
+		}
+	}
+	return false
+}
+
+// etagMatches checks if an If-None-Match header matches the given etag
+// see https://tools.ietf.org/html/rfc7232#section-3.2
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// iconClass returns the configured icon class for a mimetype, falling back to the class of its major type
+func (s *svc) iconClass(mimeType string) string {
+	if c, ok := s.c.IconClasses[mimeType]; ok {
+		return c
+	}
+	if i := strings.Index(mimeType, "/"); i > 0 {
+		if c, ok := s.c.IconClasses[mimeType[:i+1]]; ok {
+			return c
+		}
+	}
+	return "icon-file"
+}
+
 func requiresExplicitFetching(n *xml.Name) bool {
 	switch n.Space {
 	case _nsDav:
@@ -278,14 +475,42 @@ func readPropfind(r io.Reader) (pf propfindXML, status int, err error) {
 }
 
 func (s *svc) formatPropfind(ctx context.Context, pf *propfindXML, mds []*provider.ResourceInfo, ns string) (string, error) {
+	responses, err := s.propfindResponses(ctx, pf, mds, ns)
+	if err != nil {
+		return "", err
+	}
+	return multistatus(responses)
+}
+
+func (s *svc) propfindResponses(ctx context.Context, pf *propfindXML, mds []*provider.ResourceInfo, ns string) ([]*responseXML, error) {
 	responses := make([]*responseXML, 0, len(mds))
 	for i := range mds {
 		res, err := s.mdToPropResponse(ctx, pf, mds[i], ns)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 		responses = append(responses, res)
 	}
+	return responses, nil
+}
+
+// truncatedResponse tells clients that a listing has been cut off after max results
+// see https://tools.ietf.org/html/rfc5323#section-5.3
+func truncatedResponse(href string, max int) *responseXML {
+	return &responseXML{
+		Href:   encodePath(href + "/"),
+		Status: "HTTP/1.1 507 Insufficient Storage",
+		Error: &errorXML{
+			Xmlnsd:    "DAV:",
+			Xmlnss:    "http://sabredav.org/ns",
+			Exception: codesEnum[SabredavInsufficientStorage],
+			Message:   fmt.Sprintf("listing truncated after %d results", max),
+			InnerXML:  []byte("<d:number-of-matches-within-limits/>"),
+		},
+	}
+}
+
+func multistatus(responses []*responseXML) (string, error) {
 	responsesXML, err := xml.Marshal(&responses)
 	if err != nil {
 		return "", err
@@ -355,6 +580,7 @@ func (s *svc) mdToPropResponse(ctx context.Context, pf *propfindXML, md *provide
 	// -3 indicates unlimited
 	quota := _propQuotaUnknown
 	size := fmt.Sprintf("%d", md.Size)
+	sizeKnown := true
 	// TODO refactor helper functions: GetOpaqueJSONEncoded(opaque, key string, *struct) err, GetOpaquePlainEncoded(opaque, key) value, err
 	// or use ok like pattern and return bool?
 	if md.Opaque != nil && md.Opaque.Map != nil {
@@ -368,6 +594,14 @@ func (s *svc) mdToPropResponse(ctx context.Context, pf *propfindXML, md *provide
 		if md.Opaque.Map["quota"] != nil && md.Opaque.Map["quota"].Decoder == "plain" {
 			quota = string(md.Opaque.Map["quota"].Value)
 		}
+		// storages can announce the propagated size of a container, an empty treesize means it is unknown
+		if md.Opaque.Map["treesize"] != nil && md.Opaque.Map["treesize"].Decoder == "plain" {
+			if _, err := strconv.ParseUint(string(md.Opaque.Map["treesize"].Value), 10, 64); err == nil {
+				size = string(md.Opaque.Map["treesize"].Value)
+			} else {
+				sizeKnown = false
+			}
+		}
 	}
 
 	role := conversions.RoleFromResourcePermissions(md.PermissionSet)
@@ -419,7 +653,7 @@ func (s *svc) mdToPropResponse(ctx context.Context, pf *propfindXML, md *provide
 		// always return size, well nearly always ... public link shares are a little weird
 		if md.Type == provider.ResourceType_RESOURCE_TYPE_CONTAINER {
 			propstatOK.Prop = append(propstatOK.Prop, s.newPropRaw("d:resourcetype", "<d:collection/>"))
-			if ls == nil {
+			if ls == nil && sizeKnown {
 				propstatOK.Prop = append(propstatOK.Prop, s.newProp("oc:size", size))
 			}
 			// A <DAV:allprop> PROPFIND request SHOULD NOT return DAV:quota-available-bytes and DAV:quota-used-bytes
@@ -486,7 +720,17 @@ func (s *svc) mdToPropResponse(ctx context.Context, pf *propfindXML, md *provide
 				propstatOK.Prop = append(propstatOK.Prop, s.newProp("oc:favorite", "0"))
 			}
 		}
-		// TODO return other properties ... but how do we put them in a namespace?
+		// return custom properties that have explicitly been included
+		for i := range pf.Include {
+			switch pf.Include[i].Space {
+			case _nsDav, _nsOwncloud, _nsOCS:
+				// TODO return other properties ... but how do we put them in a namespace?
+				continue
+			}
+			if v, ok := md.GetArbitraryMetadata().GetMetadata()[metadataKeyOf(&pf.Include[i])]; ok && v != "" {
+				propstatOK.Prop = append(propstatOK.Prop, s.newPropNS(pf.Include[i].Space, pf.Include[i].Local, v))
+			}
+		}
 	} else {
 		// otherwise return only the requested properties
 		for i := range pf.Prop {
@@ -571,10 +815,10 @@ func (s *svc) mdToPropResponse(ctx context.Context, pf *propfindXML, md *provide
 					// TODO we cannot find out if md.Size is set or not because ints in go default to 0
 					// TODO what is the difference to d:quota-used-bytes (which only exists for collections)?
 					// oc:size is available on files and folders and behaves like d:getcontentlength or d:quota-used-bytes respectively
-					if ls == nil {
+					if ls == nil && sizeKnown {
 						propstatOK.Prop = append(propstatOK.Prop, s.newProp("oc:size", size))
 					} else {
-						// link share root collection has no size
+						// link share root collection and containers with an unknown treesize have no size
 						propstatNotFound.Prop = append(propstatNotFound.Prop, s.newProp("oc:size", ""))
 					}
 				case "owner-id": // phoenix only
@@ -683,6 +927,10 @@ func (s *svc) mdToPropResponse(ctx context.Context, pf *propfindXML, md *provide
 					} else {
 						propstatNotFound.Prop = append(propstatNotFound.Prop, s.newProp("oc:"+pf.Prop[i].Local, ""))
 					}
+				case "icon-class": // web
+					propstatOK.Prop = append(propstatOK.Prop, s.newProp("oc:icon-class", s.iconClass(md.MimeType)))
+				case "has-preview": // web
+					propstatOK.Prop = append(propstatOK.Prop, s.newProp("oc:has-preview", strconv.FormatBool(s.hasPreview(md))))
 				case "privatelink": // phoenix only
 					// <oc:privatelink>https://phoenix.owncloud.com/f/9</oc:privatelink>
 					fallthrough
@@ -696,7 +944,12 @@ func (s *svc) mdToPropResponse(ctx context.Context, pf *propfindXML, md *provide
 					// TODO(jfd): double check the client behavior with reva on backup restore
 					fallthrough
 				default:
-					propstatNotFound.Prop = append(propstatNotFound.Prop, s.newProp("oc:"+pf.Prop[i].Local, ""))
+					// the configured upload metadata keys are stored as arbitrary metadata
+					if v := md.GetArbitraryMetadata().GetMetadata()[metadataKeyOf(&pf.Prop[i])]; v != "" && s.isUploadMetadataKey(&pf.Prop[i]) {
+						propstatOK.Prop = append(propstatOK.Prop, s.newProp("oc:"+pf.Prop[i].Local, v))
+					} else {
+						propstatNotFound.Prop = append(propstatNotFound.Prop, s.newProp("oc:"+pf.Prop[i].Local, ""))
+					}
 				}
 			case _nsDav:
 				switch pf.Prop[i].Local {
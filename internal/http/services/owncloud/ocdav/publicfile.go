@@ -140,7 +140,11 @@ func (s *svc) handlePropfindOnToken(w http.ResponseWriter, r *http.Request, ns s
 
 	depth := r.Header.Get("Depth")
 	if depth == "" {
-		depth = "1"
+		depth = s.c.DefaultPropfindDepth
+		// there is nothing below the shared file, so a default of infinity lists the same as depth 1
+		if depth == "infinity" {
+			depth = "1"
+		}
 	}
 
 	// see https://tools.ietf.org/html/rfc4918#section-10.2
@@ -150,6 +154,26 @@ func (s *svc) handlePropfindOnToken(w http.ResponseWriter, r *http.Request, ns s
 		return
 	}
 
+	// a public file link only ever contains the shared file, there is no directory structure to traverse
+	// see https://tools.ietf.org/html/rfc4918#section-9.1
+	if depth == "infinity" {
+		sublog.Debug().Msg("Depth infinity is not supported on public file links")
+		w.WriteHeader(http.StatusForbidden)
+		b, err := Marshal(exception{
+			code:         SabredavForbidden,
+			message:      "Depth infinity is not supported on public file links.",
+			precondition: "propfind-finite-depth",
+		})
+		if err != nil {
+			sublog.Error().Msgf("error marshaling xml response: %s", b)
+			return
+		}
+		if _, err = w.Write(b); err != nil {
+			sublog.Err(err).Msg("error writing response")
+		}
+		return
+	}
+
 	pf, status, err := readPropfind(r.Body)
 	if err != nil {
 		sublog.Debug().Err(err).Msg("error reading propfind request")
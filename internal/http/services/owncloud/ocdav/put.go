@@ -140,6 +140,27 @@ func (s *svc) handlePut(w http.ResponseWriter, r *http.Request, ns string) {
 		}
 	}
 
+	if s.exceedsMaxUploadSize(length) {
+		sublog.Debug().Int64("length", length).Int64("max", s.c.MaxUploadSize).Msg("upload exceeds max upload size")
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// ownCloud chunking v1 uploads every chunk with a PUT, check the size of the assembled file as well
+	if totalLength := r.Header.Get("OC-Total-Length"); totalLength != "" {
+		tl, err := strconv.ParseInt(totalLength, 10, 64)
+		if err != nil {
+			sublog.Debug().Str("oc-total-length", totalLength).Msg("invalid OC-Total-Length")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if s.exceedsMaxUploadSize(tl) {
+			sublog.Debug().Int64("total_length", tl).Int64("max", s.c.MaxUploadSize).Msg("chunked upload exceeds max upload size")
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+	}
+
 	s.handlePutHelper(w, r, r.Body, fn, length)
 }
 
@@ -187,6 +208,11 @@ func (s *svc) handlePutHelper(w http.ResponseWriter, r *http.Request, content io
 				return
 			}
 		}
+		if modifiedSince(r.Header.Get("If-Unmodified-Since"), info) {
+			sublog.Debug().Str("if-unmodified-since", r.Header.Get("If-Unmodified-Since")).Msg("resource was modified")
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
 	}
 
 	opaqueMap := map[string]*typespb.OpaqueEntry{
@@ -206,6 +232,19 @@ func (s *svc) handlePutHelper(w http.ResponseWriter, r *http.Request, content io
 		w.Header().Set("X-OC-Mtime", "accepted")
 	}
 
+	// ownCloud chunking v1 sends the size of the assembled file with every chunk
+	if totalLength := r.Header.Get("OC-Total-Length"); totalLength != "" {
+		if _, err := strconv.ParseInt(totalLength, 10, 64); err != nil {
+			sublog.Debug().Str("oc-total-length", totalLength).Msg("invalid OC-Total-Length")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		opaqueMap["OC-Total-Length"] = &typespb.OpaqueEntry{
+			Decoder: "plain",
+			Value:   []byte(totalLength),
+		}
+	}
+
 	// curl -X PUT https://demo.owncloud.com/remote.php/webdav/testcs.bin -u demo:demo -d '123' -v -H 'OC-Checksum: SHA1:40bd001563085fc35165329ea1ff5c5ecbdbbeef'
 
 	var cparts []string
@@ -226,7 +265,7 @@ func (s *svc) handlePutHelper(w http.ResponseWriter, r *http.Request, content io
 			return
 		}
 	}
-	// we do not check the algorithm here, because it might depend on the storage
+	// the algorithm depends on the storage, the storage provider rejects unsupported ones before initiating the upload
 	if len(cparts) == 2 {
 		// Translate into TUS style Upload-Checksum header
 		opaqueMap["Upload-Checksum"] = &typespb.OpaqueEntry{
@@ -250,18 +289,25 @@ func (s *svc) handlePutHelper(w http.ResponseWriter, r *http.Request, content io
 	}
 
 	if uRes.Status.Code != rpc.Code_CODE_OK {
+		if uRes.Status.Code == rpc.Code_CODE_INVALID_ARGUMENT {
+			// e.g. an unsupported checksum algorithm, tell the client why
+			writeBadRequest(&sublog, w, uRes.Status.Message)
+			return
+		}
 		HandleErrorStatus(&sublog, w, uRes.Status)
 		return
 	}
 
-	var ep, token string
-	for _, p := range uRes.Protocols {
-		if p.Protocol == "simple" {
-			ep, token = p.UploadEndpoint, p.Token
+	// zero-length uploads are finished by the storage when they are initiated,
+	// so there is no content to transfer to the data service
+	if length > 0 {
+		var ep, token string
+		for _, p := range uRes.Protocols {
+			if p.Protocol == "simple" {
+				ep, token = p.UploadEndpoint, p.Token
+			}
 		}
-	}
 
-	if length > 0 {
 		httpReq, err := rhttp.NewRequest(ctx, "PUT", ep, content)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
@@ -338,6 +384,9 @@ func (s *svc) handlePutHelper(w http.ResponseWriter, r *http.Request, content io
 	}
 
 	newInfo := sRes.Info
+	if length == 0 && newInfo.Size != 0 {
+		sublog.Warn().Uint64("size", newInfo.Size).Msg("zero-length upload resulted in a non-empty file")
+	}
 
 	w.Header().Add("Content-Type", newInfo.MimeType)
 	w.Header().Set("ETag", newInfo.Etag)
@@ -356,3 +405,22 @@ func (s *svc) handlePutHelper(w http.ResponseWriter, r *http.Request, content io
 	// overwrite
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// exceedsMaxUploadSize checks the declared length of an upload against the configured maximum
+func (s *svc) exceedsMaxUploadSize(length int64) bool {
+	return s.c.MaxUploadSize > 0 && length > s.c.MaxUploadSize
+}
+
+// modifiedSince checks if the resource was modified after the time given in an If-Unmodified-Since header.
+// Invalid dates are ignored, see https://tools.ietf.org/html/rfc7232#section-3.4
+func modifiedSince(header string, info *provider.ResourceInfo) bool {
+	if header == "" || info.Mtime == nil {
+		return false
+	}
+	since, err := http.ParseTime(header)
+	if err != nil {
+		return false
+	}
+	// http dates only have a resolution of seconds
+	return utils.TSToTime(info.Mtime).Truncate(time.Second).After(since)
+}
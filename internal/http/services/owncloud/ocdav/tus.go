@@ -19,6 +19,7 @@
 package ocdav
 
 import (
+	"encoding/json"
 	"net/http"
 	"path"
 	"strconv"
@@ -56,6 +57,17 @@ func (s *svc) handleTusPost(w http.ResponseWriter, r *http.Request, ns string) {
 		w.WriteHeader(http.StatusPreconditionFailed)
 		return
 	}
+	if s.c.MaxUploadSize > 0 {
+		uploadLength, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if s.exceedsMaxUploadSize(uploadLength) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+	}
 	// r.Header.Get("OC-Checksum")
 	// TODO must be SHA1, ADLER32 or MD5 ... in capital letters????
 	// curl -X PUT https://demo.owncloud.com/remote.php/webdav/testcs.bin -u demo:demo -d '123' -v -H 'OC-Checksum: SHA1:40bd001563085fc35165329ea1ff5c5ecbdbbeef'
@@ -117,6 +129,11 @@ func (s *svc) handleTusPost(w http.ResponseWriter, r *http.Request, ns string) {
 				return
 			}
 		}
+		if modifiedSince(r.Header.Get("If-Unmodified-Since"), info) {
+			sublog.Debug().Str("if-unmodified-since", r.Header.Get("If-Unmodified-Since")).Msg("resource was modified")
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
 	}
 
 	opaqueMap := map[string]*typespb.OpaqueEntry{
@@ -134,6 +151,19 @@ func (s *svc) handleTusPost(w http.ResponseWriter, r *http.Request, ns string) {
 		}
 	}
 
+	if md := s.uploadMetadata(meta); len(md) > 0 {
+		b, err := json.Marshal(md)
+		if err != nil {
+			sublog.Error().Err(err).Msg("error marshaling upload metadata")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		opaqueMap["Upload-Metadata"] = &typespb.OpaqueEntry{
+			Decoder: "json",
+			Value:   b,
+		}
+	}
+
 	// initiateUpload
 	uReq := &provider.InitiateFileUploadRequest{
 		Ref: &provider.Reference{
@@ -260,3 +290,14 @@ func (s *svc) handleTusPost(w http.ResponseWriter, r *http.Request, ns string) {
 
 	w.WriteHeader(http.StatusCreated)
 }
+
+// uploadMetadata returns the configured keys of the TUS Upload-Metadata as arbitrary metadata in the owncloud namespace
+func (s *svc) uploadMetadata(meta map[string]string) map[string]string {
+	md := map[string]string{}
+	for _, k := range s.c.UploadMetadataKeys {
+		if v := meta[k]; v != "" {
+			md[_nsOwncloud+"/"+k] = v
+		}
+	}
+	return md
+}
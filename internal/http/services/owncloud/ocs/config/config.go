@@ -32,12 +32,14 @@ type Config struct {
 	DefaultUploadProtocol   string                            `mapstructure:"default_upload_protocol"`
 	UserAgentChunkingMap    map[string]string                 `mapstructure:"user_agent_chunking_map"`
 	SharePrefix             string                            `mapstructure:"share_prefix"`
+	ShareJailTemplate       string                            `mapstructure:"share_jail_template"`
 	HomeNamespace           string                            `mapstructure:"home_namespace"`
 	AdditionalInfoAttribute string                            `mapstructure:"additional_info_attribute"`
 	CacheWarmupDriver       string                            `mapstructure:"cache_warmup_driver"`
 	CacheWarmupDrivers      map[string]map[string]interface{} `mapstructure:"cache_warmup_drivers"`
 	ResourceInfoCacheSize   int                               `mapstructure:"resource_info_cache_size"`
 	ResourceInfoCacheTTL    int                               `mapstructure:"resource_info_cache_ttl"`
+	StatConcurrency         int                               `mapstructure:"stat_concurrency"`
 }
 
 // Init sets sane defaults
@@ -66,5 +68,9 @@ func (c *Config) Init() {
 		c.ResourceInfoCacheSize = 1000000
 	}
 
+	if c.StatConcurrency == 0 {
+		c.StatConcurrency = 10
+	}
+
 	c.GatewaySvc = sharedconf.GetGatewaySVC(c.GatewaySvc)
 }
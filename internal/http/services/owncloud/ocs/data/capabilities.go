@@ -42,6 +42,15 @@ func (c ocsBool) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
 	return e.EncodeElement("0", start)
 }
 
+// ocsList implements the xml Marshaler interface. Unlike a "name>element" tag it lets the list be omitted when empty.
+type ocsList []string
+
+func (l ocsList) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return e.EncodeElement(struct {
+		Elements []string `xml:"element"`
+	}{l}, start)
+}
+
 // CapabilitiesData TODO document
 type CapabilitiesData struct {
 	Capabilities *Capabilities `json:"capabilities" xml:"capabilities"`
@@ -126,6 +135,8 @@ type CapabilitiesFilesSharing struct {
 	Federation                    *CapabilitiesFilesSharingFederation      `json:"federation" xml:"federation"`
 	Public                        *CapabilitiesFilesSharingPublic          `json:"public" xml:"public"`
 	User                          *CapabilitiesFilesSharingUser            `json:"user" xml:"user"`
+	// PermissionTemplates lists the permission templates clients can request instead of a full permission set
+	PermissionTemplates ocsList `json:"permission_templates,omitempty" xml:"permission_templates,omitempty" mapstructure:"permission_templates"`
 }
 
 // CapabilitiesFilesSharingPublic TODO document
@@ -160,8 +171,14 @@ type CapabilitiesFilesSharingPublicExpireDate struct {
 
 // CapabilitiesFilesSharingUser TODO document
 type CapabilitiesFilesSharingUser struct {
-	SendMail       ocsBool `json:"send_mail" xml:"send_mail" mapstructure:"send_mail"`
-	ProfilePicture ocsBool `json:"profile_picture" xml:"profile_picture" mapstructure:"profile_picture"`
+	SendMail       ocsBool                                 `json:"send_mail" xml:"send_mail" mapstructure:"send_mail"`
+	ProfilePicture ocsBool                                 `json:"profile_picture" xml:"profile_picture" mapstructure:"profile_picture"`
+	ExpireDate     *CapabilitiesFilesSharingUserExpireDate `json:"expire_date" xml:"expire_date" mapstructure:"expire_date"`
+}
+
+// CapabilitiesFilesSharingUserExpireDate tells clients if user shares can expire
+type CapabilitiesFilesSharingUserExpireDate struct {
+	Enabled ocsBool `json:"enabled" xml:"enabled"`
 }
 
 // CapabilitiesFilesSharingUserEnumeration TODO document
@@ -19,15 +19,20 @@
 package shares
 
 import (
+	"context"
+	"encoding/json"
 	"net/http"
-	"path"
 
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
 	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
 	collaboration "github.com/cs3org/go-cs3apis/cs3/sharing/collaboration/v1beta1"
-	"github.com/cs3org/reva/internal/http/services/owncloud/ocs/conversions"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	types "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
 	"github.com/cs3org/reva/internal/http/services/owncloud/ocs/response"
 	"github.com/cs3org/reva/pkg/appctx"
+	"github.com/cs3org/reva/pkg/conversions"
 	"github.com/cs3org/reva/pkg/rgrpc/todo/pool"
+	"github.com/cs3org/reva/pkg/utils"
 	"github.com/pkg/errors"
 )
 
@@ -101,9 +106,53 @@ func (h *Handler) updateReceivedShare(w http.ResponseWriter, r *http.Request, sh
 
 	if data.State == ocsStateAccepted {
 		// Needed because received shares can be jailed in a folder in the users home
-		data.FileTarget = path.Join(h.sharePrefix, path.Base(info.Path))
-		data.Path = path.Join(h.sharePrefix, path.Base(info.Path))
+		data.FileTarget = h.receivedSharePath(rs.Share, info)
+		data.Path = data.FileTarget
+
+		// the recipient might have received the same resource via other shares
+		lrsRes, err := h.listReceivedSharesOfResource(ctx, client, rs.Share.ResourceId)
+		switch {
+		case err != nil:
+			logger.Debug().Err(err).Msg("could not list received shares, not merging permissions")
+		case lrsRes.Status.Code != rpc.Code_CODE_OK:
+			logger.Debug().Interface("status", lrsRes.Status).Msg("could not list received shares, not merging permissions")
+		default:
+			data.Permissions = mergePermissions(rs, lrsRes.Shares)
+		}
 	}
 
 	response.WriteOCSSuccess(w, r, []*conversions.ShareData{data})
 }
+
+// mergePermissions returns the OCS permissions of the received share combined with the
+// permissions of all other accepted shares of the same resource.
+func mergePermissions(rs *collaboration.ReceivedShare, shares []*collaboration.ReceivedShare) conversions.Permissions {
+	merged := conversions.RoleFromResourcePermissions(rs.GetShare().GetPermissions().GetPermissions()).OCSPermissions()
+	for _, s := range shares {
+		if s.GetState() != collaboration.ShareState_SHARE_STATE_ACCEPTED ||
+			s.GetShare().GetId().GetOpaqueId() == rs.GetShare().GetId().GetOpaqueId() ||
+			!utils.ResourceEqual(s.GetShare().GetResourceId(), rs.GetShare().GetResourceId()) {
+			continue
+		}
+		merged |= conversions.RoleFromResourcePermissions(s.GetShare().GetPermissions().GetPermissions()).OCSPermissions()
+	}
+	return merged
+}
+
+// listReceivedSharesOfResource lists the received shares of the current user for the given resource only.
+func (h *Handler) listReceivedSharesOfResource(ctx context.Context, client gateway.GatewayAPIClient, id *provider.ResourceId) (*collaboration.ListReceivedSharesResponse, error) {
+	rid, err := json.Marshal(id)
+	if err != nil {
+		return nil, err
+	}
+	return client.ListReceivedShares(ctx, &collaboration.ListReceivedSharesRequest{
+		Opaque: &types.Opaque{
+			Map: map[string]*types.OpaqueEntry{
+				"resource_id": {
+					Decoder: "json",
+					Value:   rid,
+				},
+			},
+		},
+	})
+}
@@ -29,6 +29,7 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 
@@ -45,13 +46,15 @@ import (
 	"github.com/bluele/gcache"
 	"github.com/cs3org/reva/internal/http/services/owncloud/ocdav"
 	"github.com/cs3org/reva/internal/http/services/owncloud/ocs/config"
-	"github.com/cs3org/reva/internal/http/services/owncloud/ocs/conversions"
 	"github.com/cs3org/reva/internal/http/services/owncloud/ocs/response"
 	"github.com/cs3org/reva/pkg/appctx"
+	"github.com/cs3org/reva/pkg/conversions"
 	"github.com/cs3org/reva/pkg/rgrpc/todo/pool"
 	"github.com/cs3org/reva/pkg/rhttp/router"
+	"github.com/cs3org/reva/pkg/share"
 	"github.com/cs3org/reva/pkg/share/cache"
 	"github.com/cs3org/reva/pkg/share/cache/registry"
+	"github.com/cs3org/reva/pkg/sharedconf"
 	"github.com/pkg/errors"
 )
 
@@ -60,11 +63,13 @@ type Handler struct {
 	gatewayAddr            string
 	publicURL              string
 	sharePrefix            string
+	shareJailTemplate      *template.Template
 	homeNamespace          string
 	additionalInfoTemplate *template.Template
 	userIdentifierCache    *ttlcache.Cache
 	resourceInfoCache      gcache.Cache
 	resourceInfoCacheTTL   time.Duration
+	statConcurrency        int
 }
 
 // we only cache the minimal set of data instead of the full user metadata
@@ -89,9 +94,15 @@ func (h *Handler) Init(c *config.Config) error {
 	h.homeNamespace = c.HomeNamespace
 	h.resourceInfoCache = gcache.New(c.ResourceInfoCacheSize).LFU().Build()
 	h.resourceInfoCacheTTL = time.Second * time.Duration(c.ResourceInfoCacheTTL)
+	h.statConcurrency = c.StatConcurrency
 
 	h.additionalInfoTemplate, _ = template.New("additionalInfo").Parse(c.AdditionalInfoAttribute)
 
+	var err error
+	if h.shareJailTemplate, err = share.ParseJailTemplate(sharedconf.GetShareJailTemplate(c.ShareJailTemplate)); err != nil {
+		return err
+	}
+
 	h.userIdentifierCache = ttlcache.NewCache()
 	_ = h.userIdentifierCache.SetTTL(time.Second * 60)
 
@@ -615,30 +626,39 @@ func (h *Handler) listSharesWithMe(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	shares := make([]*conversions.ShareData, 0, len(lrsRes.GetShares()))
-
-	// TODO(refs) filter out "invalid" shares
+	receivedShares := make([]*collaboration.ReceivedShare, 0, len(lrsRes.GetShares()))
 	for _, rs := range lrsRes.GetShares() {
 		if stateFilter != ocsStateUnknown && rs.GetState() != stateFilter {
 			continue
 		}
-		var info *provider.ResourceInfo
-		if pinfo != nil {
-			// check if the shared resource matches the path resource
-			if rs.Share.ResourceId.StorageId != pinfo.GetId().StorageId ||
-				rs.Share.ResourceId.OpaqueId != pinfo.GetId().OpaqueId {
-				// try next share
-				continue
-			}
-			// we can reuse the stat info
-			info = pinfo
-		} else {
-			var status *rpc.Status
-			info, status, err = h.getResourceInfoByID(ctx, client, rs.Share.ResourceId)
-			if err != nil || status.Code != rpc.Code_CODE_OK {
-				h.logProblems(status, err, "could not stat, skipping")
-				continue
-			}
+		// check if the shared resource matches the path resource
+		if pinfo != nil && (rs.Share.ResourceId.StorageId != pinfo.GetId().StorageId ||
+			rs.Share.ResourceId.OpaqueId != pinfo.GetId().OpaqueId) {
+			continue
+		}
+		receivedShares = append(receivedShares, rs)
+	}
+
+	var infos []*provider.ResourceInfo
+	if pinfo != nil {
+		// we can reuse the stat info
+		infos = make([]*provider.ResourceInfo, len(receivedShares))
+		for i := range infos {
+			infos[i] = pinfo
+		}
+	} else {
+		infos = h.statReceivedShares(ctx, receivedShares, func(ctx context.Context, id *provider.ResourceId) (*provider.ResourceInfo, *rpc.Status, error) {
+			return h.getResourceInfoByID(ctx, client, id)
+		})
+	}
+
+	shares := make([]*conversions.ShareData, 0, len(receivedShares))
+
+	// TODO(refs) filter out "invalid" shares
+	for i, rs := range receivedShares {
+		info := infos[i]
+		if info == nil {
+			continue
 		}
 
 		data, err := conversions.CS3Share2ShareData(r.Context(), rs.Share)
@@ -657,8 +677,8 @@ func (h *Handler) listSharesWithMe(w http.ResponseWriter, r *http.Request) {
 
 		if data.State == ocsStateAccepted {
 			// Needed because received shares can be jailed in a folder in the users home
-			data.FileTarget = path.Join(h.sharePrefix, path.Base(info.Path))
-			data.Path = path.Join(h.sharePrefix, path.Base(info.Path))
+			data.FileTarget = h.receivedSharePath(rs.Share, info)
+			data.Path = data.FileTarget
 		}
 
 		shares = append(shares, data)
@@ -667,6 +687,55 @@ func (h *Handler) listSharesWithMe(w http.ResponseWriter, r *http.Request) {
 	response.WriteOCSSuccess(w, r, shares)
 }
 
+// statReceivedShares stats the resources of the received shares with at most statConcurrency requests in parallel.
+// The returned infos have the same order as the shares. Shares that could not be stated have a nil info.
+func (h *Handler) statReceivedShares(ctx context.Context, shares []*collaboration.ReceivedShare, stat func(context.Context, *provider.ResourceId) (*provider.ResourceInfo, *rpc.Status, error)) []*provider.ResourceInfo {
+	infos := make([]*provider.ResourceInfo, len(shares))
+
+	workers := h.statConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(shares) {
+		workers = len(shares)
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				info, status, err := stat(ctx, shares[i].GetShare().GetResourceId())
+				if err != nil || status.Code != rpc.Code_CODE_OK {
+					h.logProblems(status, err, "could not stat, skipping")
+					continue
+				}
+				infos[i] = info
+			}
+		}()
+	}
+	for i := range shares {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return infos
+}
+
+// receivedSharePath returns the path of an accepted received share in the share jail.
+// The gateway mounts the share with the same name when it is accepted.
+func (h *Handler) receivedSharePath(s *collaboration.Share, info *provider.ResourceInfo) string {
+	name, err := share.MountName(h.shareJailTemplate, s, info)
+	if err != nil {
+		log.Error().Err(err).Str("share", s.GetId().GetOpaqueId()).Msg("could not build share jail name")
+		name = path.Base(info.GetPath())
+	}
+	return path.Join(h.sharePrefix, name)
+}
+
 func (h *Handler) listSharesWithOthers(w http.ResponseWriter, r *http.Request) {
 	shares := make([]*conversions.ShareData, 0)
 
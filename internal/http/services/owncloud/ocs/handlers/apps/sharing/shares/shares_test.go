@@ -18,11 +18,103 @@
 package shares
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strings"
 	"testing"
+	"time"
 
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
 	collaboration "github.com/cs3org/go-cs3apis/cs3/sharing/collaboration/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/cs3org/reva/internal/grpc/services/usershareprovider"
+	"github.com/cs3org/reva/internal/http/services/owncloud/ocs/config"
+	"github.com/cs3org/reva/pkg/conversions"
+	"github.com/cs3org/reva/pkg/rgrpc/status"
+	_ "github.com/cs3org/reva/pkg/share/manager/json"
+	_ "github.com/cs3org/reva/pkg/share/manager/memory"
+	"github.com/cs3org/reva/pkg/user"
+	"google.golang.org/grpc"
 )
 
+// testGateway answers the gateway calls made by the handlers under test. Share requests
+// are passed on to a user share provider acting on behalf of the sharer.
+type testGateway struct {
+	gateway.UnimplementedGatewayAPIServer
+	shares    collaboration.CollaborationAPIServer
+	sharer    *userpb.User
+	recipient *userpb.User
+	info      *provider.ResourceInfo
+
+	listedReceived []*collaboration.ReceivedShare
+}
+
+func (g *testGateway) Stat(ctx context.Context, req *provider.StatRequest) (*provider.StatResponse, error) {
+	return &provider.StatResponse{Status: status.NewOK(ctx), Info: g.info}, nil
+}
+
+func (g *testGateway) GetUserByClaim(ctx context.Context, req *userpb.GetUserByClaimRequest) (*userpb.GetUserByClaimResponse, error) {
+	return &userpb.GetUserByClaimResponse{
+		Status: status.NewOK(ctx),
+		User:   &userpb.User{Id: &userpb.UserId{Idp: "idp", OpaqueId: req.Value}, Username: req.Value},
+	}, nil
+}
+
+func (g *testGateway) CreateShare(ctx context.Context, req *collaboration.CreateShareRequest) (*collaboration.CreateShareResponse, error) {
+	return g.shares.CreateShare(user.ContextSetUser(ctx, g.sharer), req)
+}
+
+func (g *testGateway) GetShare(ctx context.Context, req *collaboration.GetShareRequest) (*collaboration.GetShareResponse, error) {
+	return g.shares.GetShare(user.ContextSetUser(ctx, g.sharer), req)
+}
+
+func (g *testGateway) UpdateShare(ctx context.Context, req *collaboration.UpdateShareRequest) (*collaboration.UpdateShareResponse, error) {
+	return g.shares.UpdateShare(user.ContextSetUser(ctx, g.sharer), req)
+}
+
+func (g *testGateway) UpdateReceivedShare(ctx context.Context, req *collaboration.UpdateReceivedShareRequest) (*collaboration.UpdateReceivedShareResponse, error) {
+	return g.shares.UpdateReceivedShare(user.ContextSetUser(ctx, g.recipient), req)
+}
+
+func (g *testGateway) ListReceivedShares(ctx context.Context, req *collaboration.ListReceivedSharesRequest) (*collaboration.ListReceivedSharesResponse, error) {
+	res, err := g.shares.ListReceivedShares(user.ContextSetUser(ctx, g.recipient), req)
+	if err == nil {
+		g.listedReceived = res.Shares
+	}
+	return res, err
+}
+
+func listen(t *testing.T) net.Listener {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	return lis
+}
+
+// newTestHandler serves the gateway on lis and returns a handler using it
+func newTestHandler(t *testing.T, lis net.Listener, g *testGateway) *Handler {
+	srv := grpc.NewServer()
+	gateway.RegisterGatewayAPIServer(srv, g)
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+
+	c := &config.Config{GatewaySvc: lis.Addr().String()}
+	c.Init()
+	h := &Handler{}
+	if err := h.Init(c); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	return h
+}
+
 func TestGetStateFilter(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -70,3 +162,228 @@ func TestMapState(t *testing.T) {
 		}
 	}
 }
+
+func TestReceivedSharePath(t *testing.T) {
+	share := &collaboration.Share{
+		Id:    &collaboration.ShareId{OpaqueId: "share-1"},
+		Owner: &userpb.UserId{OpaqueId: "einstein"},
+	}
+	info := &provider.ResourceInfo{Path: "/home/einstein/Projects/reva"}
+
+	tests := []struct {
+		prefix   string
+		template string
+		expected string
+	}{
+		{"/Shares", "", "/Shares/reva"},
+		{"/Jail", "", "/Jail/reva"},
+		{"/Shares", "{{.Owner}} - {{.Name}}", "/Shares/einstein - reva"},
+		// storage providers only mount shares directly below the share folder
+		{"/Shares", "{{.Owner}}/{{.Name}}", "/Shares/reva"},
+		{"/Shares", "{{.Name}} ({{.ShareID}})", "/Shares/reva (share-1)"},
+	}
+
+	for _, tt := range tests {
+		h := &Handler{}
+		if err := h.Init(&config.Config{SharePrefix: tt.prefix, ShareJailTemplate: tt.template, ResourceInfoCacheSize: 1}); err != nil {
+			t.Fatalf("Init failed: %v", err)
+		}
+		if p := h.receivedSharePath(share, info); p != tt.expected {
+			t.Errorf("receivedSharePath with prefix %q and template %q returned %q instead of expected %q", tt.prefix, tt.template, p, tt.expected)
+		}
+	}
+}
+
+func TestMergePermissions(t *testing.T) {
+	resource := &provider.ResourceId{StorageId: "storage", OpaqueId: "resource"}
+	other := &provider.ResourceId{StorageId: "storage", OpaqueId: "other"}
+	newReceivedShare := func(id string, rid *provider.ResourceId, role *conversions.Role, state collaboration.ShareState) *collaboration.ReceivedShare {
+		return &collaboration.ReceivedShare{
+			State: state,
+			Share: &collaboration.Share{
+				Id:          &collaboration.ShareId{OpaqueId: id},
+				ResourceId:  rid,
+				Permissions: &collaboration.SharePermissions{Permissions: role.CS3ResourcePermissions()},
+			},
+		}
+	}
+
+	accepted := newReceivedShare("viewer", resource, conversions.NewViewerRole(), collaboration.ShareState_SHARE_STATE_ACCEPTED)
+	shares := []*collaboration.ReceivedShare{
+		accepted,
+		newReceivedShare("editor", resource, conversions.NewEditorRole(), collaboration.ShareState_SHARE_STATE_ACCEPTED),
+		newReceivedShare("pending", resource, conversions.NewCoownerRole(), collaboration.ShareState_SHARE_STATE_PENDING),
+		newReceivedShare("unrelated", other, conversions.NewCoownerRole(), collaboration.ShareState_SHARE_STATE_ACCEPTED),
+	}
+
+	expected := conversions.NewEditorRole().OCSPermissions()
+	if p := mergePermissions(accepted, shares); p != expected {
+		t.Errorf("mergePermissions returned %d instead of expected %d", p, expected)
+	}
+	if p := mergePermissions(accepted, nil); p != conversions.NewViewerRole().OCSPermissions() {
+		t.Errorf("mergePermissions without overlapping shares returned %d instead of expected %d", p, conversions.NewViewerRole().OCSPermissions())
+	}
+}
+
+func TestStatReceivedSharesInParallel(t *testing.T) {
+	shares := make([]*collaboration.ReceivedShare, 10)
+	for i := range shares {
+		shares[i] = &collaboration.ReceivedShare{
+			Share: &collaboration.Share{
+				ResourceId: &provider.ResourceId{StorageId: "storage", OpaqueId: fmt.Sprintf("resource-%d", i)},
+			},
+		}
+	}
+	latency := 50 * time.Millisecond
+	stat := func(ctx context.Context, id *provider.ResourceId) (*provider.ResourceInfo, *rpc.Status, error) {
+		time.Sleep(latency)
+		if id.OpaqueId == "resource-3" {
+			return nil, &rpc.Status{Code: rpc.Code_CODE_NOT_FOUND}, nil
+		}
+		return &provider.ResourceInfo{Id: id}, &rpc.Status{Code: rpc.Code_CODE_OK}, nil
+	}
+
+	h := &Handler{statConcurrency: 5}
+	start := time.Now()
+	infos := h.statReceivedShares(context.Background(), shares, stat)
+	elapsed := time.Since(start)
+
+	// 10 shares with 5 workers need two rounds, sequential stats would need ten
+	if elapsed >= 5*latency {
+		t.Errorf("stating shares took %s, expected them to be stated in parallel", elapsed)
+	}
+	if len(infos) != len(shares) {
+		t.Fatalf("statReceivedShares returned %d infos instead of expected %d", len(infos), len(shares))
+	}
+	for i, info := range infos {
+		if i == 3 {
+			if info != nil {
+				t.Errorf("expected no info for share that could not be stated, got %v", info)
+			}
+			continue
+		}
+		if info.GetId().GetOpaqueId() != shares[i].Share.ResourceId.OpaqueId {
+			t.Errorf("info %d belongs to %s instead of expected %s", i, info.GetId().GetOpaqueId(), shares[i].Share.ResourceId.OpaqueId)
+		}
+	}
+}
+
+func TestUserShareWithDefaultPermissions(t *testing.T) {
+	einstein := &userpb.User{Id: &userpb.UserId{Idp: "idp", OpaqueId: "einstein"}, Username: "einstein"}
+	lis := listen(t)
+	// the default usershareprovider configuration, the provider stats the resource through the gateway
+	shares, err := usershareprovider.New(map[string]interface{}{"driver": "memory", "gateway_addr": lis.Addr().String()}, nil)
+	if err != nil {
+		t.Fatalf("error creating usershareprovider: %v", err)
+	}
+	h := newTestHandler(t, lis, &testGateway{
+		shares: shares.(collaboration.CollaborationAPIServer),
+		sharer: einstein,
+		info: &provider.ResourceInfo{
+			Type:          provider.ResourceType_RESOURCE_TYPE_CONTAINER,
+			Id:            &provider.ResourceId{StorageId: "storage", OpaqueId: "project"},
+			Path:          "/home/project",
+			Owner:         einstein.Id,
+			PermissionSet: conversions.NewCoownerRole().CS3ResourcePermissions(),
+		},
+	})
+
+	type ocsResponse struct {
+		OCS struct {
+			Meta struct {
+				StatusCode int `json:"statuscode"`
+			} `json:"meta"`
+			Data struct {
+				ID          string `json:"id"`
+				Permissions int    `json:"permissions"`
+			} `json:"data"`
+		} `json:"ocs"`
+	}
+	send := func(method, target string, form url.Values) ocsResponse {
+		r := httptest.NewRequest(method, target+"?format=json", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		res := ocsResponse{}
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatalf("error decoding %s response %q: %v", method, w.Body.String(), err)
+		}
+		return res
+	}
+
+	// user shares default to the coowner role, which includes the share permission
+	created := send("POST", "/", url.Values{"shareType": {"0"}, "path": {"/project"}, "shareWith": {"marie"}})
+	if created.OCS.Meta.StatusCode != 100 {
+		t.Fatalf("creating a share with default permissions returned status %d", created.OCS.Meta.StatusCode)
+	}
+	if created.OCS.Data.Permissions != int(conversions.NewCoownerRole().OCSPermissions()) {
+		t.Errorf("share was created with permissions %d instead of %d", created.OCS.Data.Permissions, conversions.NewCoownerRole().OCSPermissions())
+	}
+
+	updated := send("PUT", "/"+created.OCS.Data.ID, url.Values{"permissions": {"31"}})
+	if updated.OCS.Meta.StatusCode != 100 {
+		t.Fatalf("updating a share to permissions 31 returned status %d", updated.OCS.Meta.StatusCode)
+	}
+}
+
+func TestAcceptShareListsOnlySharesOfTheResource(t *testing.T) {
+	einstein := &userpb.User{Id: &userpb.UserId{Idp: "idp", OpaqueId: "einstein"}, Username: "einstein"}
+	marie := &userpb.User{Id: &userpb.UserId{Idp: "idp", OpaqueId: "marie"}, Username: "marie"}
+	shares, err := usershareprovider.New(map[string]interface{}{
+		"driver": "json",
+		"drivers": map[string]interface{}{
+			"json": map[string]interface{}{"file": path.Join(t.TempDir(), "shares.json")},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatalf("error creating usershareprovider: %v", err)
+	}
+	g := &testGateway{
+		shares:    shares.(collaboration.CollaborationAPIServer),
+		sharer:    einstein,
+		recipient: marie,
+		info: &provider.ResourceInfo{
+			Type:          provider.ResourceType_RESOURCE_TYPE_CONTAINER,
+			Id:            &provider.ResourceId{StorageId: "storage", OpaqueId: "project"},
+			Path:          "/home/project",
+			Owner:         einstein.Id,
+			PermissionSet: conversions.NewCoownerRole().CS3ResourcePermissions(),
+		},
+	}
+	h := newTestHandler(t, listen(t), g)
+
+	share := func(info *provider.ResourceInfo) *collaboration.Share {
+		res, err := g.CreateShare(context.Background(), &collaboration.CreateShareRequest{
+			ResourceInfo: info,
+			Grant: &collaboration.ShareGrant{
+				Grantee: &provider.Grantee{
+					Type: provider.GranteeType_GRANTEE_TYPE_USER,
+					Id:   &provider.Grantee_UserId{UserId: marie.Id},
+				},
+				Permissions: &collaboration.SharePermissions{Permissions: conversions.NewViewerRole().CS3ResourcePermissions()},
+			},
+		})
+		if err != nil || res.Status.Code != rpc.Code_CODE_OK {
+			t.Fatalf("error creating share: %v %v", err, res.GetStatus())
+		}
+		return res.Share
+	}
+	accepted := share(g.info)
+	share(&provider.ResourceInfo{
+		Type:          provider.ResourceType_RESOURCE_TYPE_CONTAINER,
+		Id:            &provider.ResourceId{StorageId: "storage", OpaqueId: "other"},
+		Path:          "/home/other",
+		Owner:         einstein.Id,
+		PermissionSet: conversions.NewCoownerRole().CS3ResourcePermissions(),
+	})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/pending/"+accepted.Id.OpaqueId+"?format=json", nil))
+	if w.Code != 200 {
+		t.Fatalf("accepting the share returned %d: %s", w.Code, w.Body.String())
+	}
+
+	if len(g.listedReceived) != 1 || g.listedReceived[0].Share.Id.OpaqueId != accepted.Id.OpaqueId {
+		t.Errorf("accepting a share listed %v, expected only the shares of the accepted resource", g.listedReceived)
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"github.com/cs3org/reva/internal/http/services/owncloud/ocs/config"
 	"github.com/cs3org/reva/internal/http/services/owncloud/ocs/data"
 	"github.com/cs3org/reva/internal/http/services/owncloud/ocs/response"
+	"github.com/cs3org/reva/pkg/conversions"
 )
 
 // Handler renders the capability endpoint
@@ -162,6 +163,11 @@ func (h *Handler) Init(c *config.Config) {
 
 	// h.c.Capabilities.FilesSharing.User.SendMail is boolean
 
+	if h.c.Capabilities.FilesSharing.User.ExpireDate == nil {
+		h.c.Capabilities.FilesSharing.User.ExpireDate = &data.CapabilitiesFilesSharingUserExpireDate{}
+	}
+	// h.c.Capabilities.FilesSharing.User.ExpireDate.Enabled is boolean
+
 	// h.c.Capabilities.FilesSharing.Resharing is boolean
 	// h.c.Capabilities.FilesSharing.GroupSharing is boolean
 	// h.c.Capabilities.FilesSharing.AutoAcceptShare is boolean
@@ -189,6 +195,11 @@ func (h *Handler) Init(c *config.Config) {
 		h.c.Capabilities.FilesSharing.SearchMinLength = 2
 	}
 
+	// the templates configured by default in the usershareprovider
+	if h.c.Capabilities.FilesSharing.PermissionTemplates == nil {
+		h.c.Capabilities.FilesSharing.PermissionTemplates = []string{conversions.RoleViewer, conversions.RoleEditor, conversions.RoleUploader}
+	}
+
 	// notifications
 
 	if h.c.Capabilities.Notifications == nil {
@@ -21,9 +21,13 @@ package capabilities
 import (
 	"encoding/json"
 	"encoding/xml"
+	"net/http/httptest"
+	"reflect"
 	"testing"
 
+	"github.com/cs3org/reva/internal/http/services/owncloud/ocs/config"
 	"github.com/cs3org/reva/internal/http/services/owncloud/ocs/data"
+	"github.com/mitchellh/mapstructure"
 )
 
 func TestMarshal(t *testing.T) {
@@ -56,3 +60,69 @@ func TestMarshal(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestSharingCapabilities(t *testing.T) {
+	tests := []struct {
+		name       string
+		conf       map[string]interface{}
+		resharing  bool
+		templates  []string
+		expiration bool
+	}{
+		{"defaults", nil, false, []string{"viewer", "editor", "uploader"}, false},
+		{"configured", map[string]interface{}{
+			"resharing":            true,
+			"permission_templates": []string{"read"},
+			"user": map[string]interface{}{
+				"expire_date": map[string]interface{}{"enabled": true},
+			},
+		}, true, []string{"read"}, true},
+	}
+
+	for _, tt := range tests {
+		sharing := &data.CapabilitiesFilesSharing{}
+		if err := mapstructure.Decode(tt.conf, sharing); err != nil {
+			t.Fatalf("%s: error decoding config: %v", tt.name, err)
+		}
+		h := &Handler{}
+		h.Init(&config.Config{
+			Capabilities: data.CapabilitiesData{
+				Capabilities: &data.Capabilities{FilesSharing: sharing},
+			},
+		})
+
+		w := httptest.NewRecorder()
+		h.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/capabilities?format=json", nil))
+
+		res := struct {
+			OCS struct {
+				Data struct {
+					Capabilities struct {
+						FilesSharing struct {
+							Resharing           bool     `json:"resharing"`
+							PermissionTemplates []string `json:"permission_templates"`
+							User                struct {
+								ExpireDate struct {
+									Enabled bool `json:"enabled"`
+								} `json:"expire_date"`
+							} `json:"user"`
+						} `json:"files_sharing"`
+					} `json:"capabilities"`
+				} `json:"data"`
+			} `json:"ocs"`
+		}{}
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatalf("%s: error decoding capabilities: %v", tt.name, err)
+		}
+		got := res.OCS.Data.Capabilities.FilesSharing
+		if got.Resharing != tt.resharing {
+			t.Errorf("%s: resharing is %t instead of %t", tt.name, got.Resharing, tt.resharing)
+		}
+		if !reflect.DeepEqual(got.PermissionTemplates, tt.templates) {
+			t.Errorf("%s: permission templates are %v instead of %v", tt.name, got.PermissionTemplates, tt.templates)
+		}
+		if got.User.ExpireDate.Enabled != tt.expiration {
+			t.Errorf("%s: user share expiration is %t instead of %t", tt.name, got.User.ExpireDate.Enabled, tt.expiration)
+		}
+	}
+}
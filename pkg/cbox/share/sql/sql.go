@@ -27,6 +27,8 @@ import (
 	"strings"
 	"time"
 
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
 	collaboration "github.com/cs3org/go-cs3apis/cs3/sharing/collaboration/v1beta1"
 	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
 	typespb "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
@@ -314,7 +316,7 @@ func (m *mgr) ListShares(ctx context.Context, filters []*collaboration.ListShare
 }
 
 // we list the shares that are targeted to the user in context or to the user groups.
-func (m *mgr) ListReceivedShares(ctx context.Context) ([]*collaboration.ReceivedShare, error) {
+func (m *mgr) ListReceivedShares(ctx context.Context, filters []*collaboration.ListSharesRequest_Filter) ([]*collaboration.ReceivedShare, error) {
 	user := user.ContextMustGetUser(ctx)
 	uid := conversions.FormatUserID(user.Id)
 
@@ -329,6 +331,12 @@ func (m *mgr) ListReceivedShares(ctx context.Context) ([]*collaboration.Received
 	} else {
 		query += "AND (share_with=?)"
 	}
+	for _, f := range filters {
+		if f.Type == collaboration.ListSharesRequest_Filter_TYPE_RESOURCE_ID {
+			query += " AND (fileid_prefix=? AND item_source=?)"
+			params = append(params, f.GetResourceId().StorageId, f.GetResourceId().OpaqueId)
+		}
+	}
 
 	rows, err := m.db.Query(query, params...)
 	if err != nil {
@@ -464,3 +472,107 @@ func (m *mgr) UpdateReceivedShare(ctx context.Context, ref *collaboration.ShareR
 	rs.State = f.GetState()
 	return rs, nil
 }
+
+// Dump returns all user and group shares and the states their recipients have set.
+// Group shares are only accepted for the whole group, so only rejections are returned for them.
+func (m *mgr) Dump(ctx context.Context) ([]*collaboration.Share, []share.ReceivedShareWithUser, error) {
+	query := "select coalesce(uid_owner, '') as uid_owner, coalesce(uid_initiator, '') as uid_initiator, coalesce(share_with, '') as share_with, coalesce(fileid_prefix, '') as fileid_prefix, coalesce(item_source, '') as item_source, id, stime, permissions, share_type, accepted FROM oc_share WHERE (orphan = 0 or orphan IS NULL) AND (share_type=? OR share_type=?)"
+	rows, err := m.db.Query(query, 0, 1)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	shares := []*collaboration.Share{}
+	byID := map[string]*collaboration.Share{}
+	accepted := map[string]bool{}
+	for rows.Next() {
+		var s conversions.DBShare
+		if err := rows.Scan(&s.UIDOwner, &s.UIDInitiator, &s.ShareWith, &s.Prefix, &s.ItemSource, &s.ID, &s.STime, &s.Permissions, &s.ShareType, &s.State); err != nil {
+			return nil, nil, err
+		}
+		cs3Share := conversions.ConvertToCS3Share(s)
+		shares = append(shares, cs3Share)
+		byID[s.ID] = cs3Share
+		if s.ShareType == 0 && conversions.IntToShareState(s.State) == collaboration.ShareState_SHARE_STATE_ACCEPTED {
+			accepted[s.ID] = true
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	rows, err = m.db.Query("select id, rejected_by FROM oc_share_acl")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	states := []share.ReceivedShareWithUser{}
+	for rows.Next() {
+		var id, rejectedBy string
+		if err := rows.Scan(&id, &rejectedBy); err != nil {
+			return nil, nil, err
+		}
+		s, ok := byID[id]
+		if !ok {
+			continue
+		}
+		// a rejection overrides the accepted flag of the share
+		delete(accepted, id)
+		states = append(states, share.ReceivedShareWithUser{
+			UserID:        conversions.ExtractUserID(rejectedBy),
+			ReceivedShare: &collaboration.ReceivedShare{Share: s, State: collaboration.ShareState_SHARE_STATE_REJECTED},
+		})
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	for _, s := range shares {
+		if accepted[s.Id.OpaqueId] {
+			states = append(states, share.ReceivedShareWithUser{
+				UserID:        s.Grantee.GetUserId(),
+				ReceivedShare: &collaboration.ReceivedShare{Share: s, State: collaboration.ShareState_SHARE_STATE_ACCEPTED},
+			})
+		}
+	}
+	return shares, states, nil
+}
+
+// VerifyShares returns the user and group shares whose resource does not exist anymore.
+// Pruning marks them as orphans, like the shares of deleted files are marked elsewhere,
+// so they are no longer listed but stay in the oc_share table.
+func (m *mgr) VerifyShares(ctx context.Context, client gateway.GatewayAPIClient, prune bool) ([]*collaboration.Share, error) {
+	shares, _, err := m.Dump(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	orphans := []*collaboration.Share{}
+	for _, s := range shares {
+		res, err := client.Stat(ctx, &provider.StatRequest{
+			Ref: &provider.Reference{Spec: &provider.Reference_Id{Id: s.ResourceId}},
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "sql: error statting the resource of share "+s.Id.OpaqueId)
+		}
+		switch res.Status.Code {
+		case rpc.Code_CODE_OK:
+			continue
+		case rpc.Code_CODE_NOT_FOUND:
+			orphans = append(orphans, s)
+		default:
+			return nil, errors.Errorf("sql: error statting the resource of share %s: %s", s.Id.OpaqueId, res.Status.Message)
+		}
+	}
+
+	if prune {
+		for _, s := range orphans {
+			if _, err := m.db.Exec("update oc_share set orphan=1 where id=?", s.Id.OpaqueId); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return orphans, nil
+}
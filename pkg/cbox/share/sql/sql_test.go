@@ -0,0 +1,211 @@
+// Copyright 2018-2021 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"path"
+	"testing"
+
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	collaboration "github.com/cs3org/go-cs3apis/cs3/sharing/collaboration/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/cs3org/reva/pkg/share"
+	"github.com/cs3org/reva/pkg/share/manager/json"
+	"github.com/cs3org/reva/pkg/user"
+	"github.com/golang/protobuf/proto"
+	_ "github.com/mattn/go-sqlite3"
+	"google.golang.org/grpc"
+)
+
+// schema contains the columns of the oc_share and oc_share_acl tables used by the manager
+const schema = `
+CREATE TABLE oc_share (
+	id integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+	share_type integer NOT NULL DEFAULT 0,
+	share_with varchar(255) DEFAULT NULL,
+	uid_owner varchar(64) NOT NULL DEFAULT '',
+	uid_initiator varchar(64) DEFAULT NULL,
+	item_type varchar(64) NOT NULL DEFAULT '',
+	fileid_prefix varchar(255) DEFAULT NULL,
+	item_source varchar(255) DEFAULT NULL,
+	file_source integer DEFAULT NULL,
+	file_target varchar(512) DEFAULT NULL,
+	permissions integer NOT NULL DEFAULT 0,
+	stime integer NOT NULL DEFAULT 0,
+	accepted integer NOT NULL DEFAULT 0,
+	token varchar(32) DEFAULT NULL,
+	orphan integer DEFAULT NULL
+);
+CREATE TABLE oc_share_acl (
+	id integer NOT NULL,
+	rejected_by varchar(255) NOT NULL
+);
+INSERT INTO oc_share (id, share_type, share_with, uid_owner, uid_initiator, item_type, fileid_prefix, item_source, permissions, stime, accepted, orphan) VALUES
+	(1, 0, 'marie', 'einstein', 'einstein', 'folder', 'storage', 'accepted', 1, 1000, 1, NULL),
+	(2, 0, 'marie', 'einstein', 'einstein', 'folder', 'storage', 'rejected', 15, 1001, 0, NULL),
+	(3, 1, 'physics', 'einstein', 'einstein', 'folder', 'storage', 'group', 1, 1002, 0, 0),
+	(4, 0, 'marie', 'einstein', 'einstein', 'folder', 'storage', 'orphan', 1, 1003, 0, 1),
+	(5, 3, '', 'einstein', 'einstein', 'folder', 'storage', 'link', 1, 1004, 0, NULL);
+INSERT INTO oc_share_acl (id, rejected_by) VALUES (2, 'marie'), (3, 'richard');
+`
+
+func newTestManager(t *testing.T) *mgr {
+	db, err := sql.Open("sqlite3", path.Join(t.TempDir(), "shares.db"))
+	if err != nil {
+		t.Fatalf("error opening database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec(schema); err != nil {
+		t.Fatalf("error creating schema: %v", err)
+	}
+	return &mgr{c: &config{}, db: db}
+}
+
+func TestMigrateToJSON(t *testing.T) {
+	from := newTestManager(t)
+	to, err := json.New(map[string]interface{}{"file": path.Join(t.TempDir(), "shares.json")})
+	if err != nil {
+		t.Fatalf("error creating json manager: %v", err)
+	}
+
+	// migrating twice must not duplicate any shares
+	for i := 0; i < 2; i++ {
+		if err := share.Migrate(context.Background(), from, to.(share.LoadableManager)); err != nil {
+			t.Fatalf("error migrating shares: %v", err)
+		}
+	}
+
+	einstein := user.ContextSetUser(context.Background(), &userpb.User{Id: &userpb.UserId{OpaqueId: "einstein"}})
+	shares, err := to.ListShares(einstein, nil)
+	if err != nil {
+		t.Fatalf("error listing shares: %v", err)
+	}
+	expected, _, err := from.Dump(context.Background())
+	if err != nil {
+		t.Fatalf("error dumping shares: %v", err)
+	}
+	if len(shares) != 3 || len(expected) != 3 {
+		t.Fatalf("migrated %d shares instead of the 3 user and group shares", len(shares))
+	}
+	for i := range expected {
+		if !proto.Equal(shares[i], expected[i]) {
+			t.Errorf("share %s was migrated as %v instead of %v", expected[i].Id.OpaqueId, shares[i], expected[i])
+		}
+	}
+
+	tests := []struct {
+		user     *userpb.User
+		id       string
+		expected collaboration.ShareState
+	}{
+		{&userpb.User{Id: &userpb.UserId{OpaqueId: "marie"}}, "1", collaboration.ShareState_SHARE_STATE_ACCEPTED},
+		{&userpb.User{Id: &userpb.UserId{OpaqueId: "marie"}}, "2", collaboration.ShareState_SHARE_STATE_REJECTED},
+		{&userpb.User{Id: &userpb.UserId{OpaqueId: "richard"}, Groups: []string{"physics"}}, "3", collaboration.ShareState_SHARE_STATE_REJECTED},
+		{&userpb.User{Id: &userpb.UserId{OpaqueId: "marie"}, Groups: []string{"physics"}}, "3", collaboration.ShareState_SHARE_STATE_PENDING},
+	}
+	for _, tt := range tests {
+		rs, err := to.GetReceivedShare(user.ContextSetUser(context.Background(), tt.user), &collaboration.ShareReference{
+			Spec: &collaboration.ShareReference_Id{Id: &collaboration.ShareId{OpaqueId: tt.id}},
+		})
+		if err != nil {
+			t.Fatalf("error getting received share %s of %s: %v", tt.id, tt.user.Id.OpaqueId, err)
+		}
+		if rs.State != tt.expected {
+			t.Errorf("received share %s of %s has state %s instead of %s", tt.id, tt.user.Id.OpaqueId, rs.State, tt.expected)
+		}
+	}
+}
+
+// storage is a gateway only knowing the resources with the given opaque ids
+type storage struct {
+	gateway.GatewayAPIClient
+	resources map[string]bool
+}
+
+func (s storage) Stat(ctx context.Context, req *provider.StatRequest, opts ...grpc.CallOption) (*provider.StatResponse, error) {
+	if !s.resources[req.Ref.GetId().GetOpaqueId()] {
+		return &provider.StatResponse{Status: &rpc.Status{Code: rpc.Code_CODE_NOT_FOUND}}, nil
+	}
+	return &provider.StatResponse{
+		Status: &rpc.Status{Code: rpc.Code_CODE_OK},
+		Info:   &provider.ResourceInfo{Id: req.Ref.GetId()},
+	}, nil
+}
+
+func TestVerifyShares(t *testing.T) {
+	m := newTestManager(t)
+	// the resource shared with share 2 has been deleted
+	client := storage{resources: map[string]bool{"accepted": true, "group": true}}
+
+	for _, prune := range []bool{false, true} {
+		orphans, err := m.VerifyShares(context.Background(), client, prune)
+		if err != nil {
+			t.Fatalf("error verifying shares: %v", err)
+		}
+		if len(orphans) != 1 || orphans[0].Id.OpaqueId != "2" {
+			t.Fatalf("expected share 2 to be reported, got %v", orphans)
+		}
+	}
+
+	// pruned shares are no longer listed or verified
+	orphans, err := m.VerifyShares(context.Background(), client, false)
+	if err != nil {
+		t.Fatalf("error verifying shares: %v", err)
+	}
+	if len(orphans) != 0 {
+		t.Errorf("expected no shares to be reported after pruning, got %v", orphans)
+	}
+	einstein := user.ContextSetUser(context.Background(), &userpb.User{Id: &userpb.UserId{OpaqueId: "einstein"}})
+	shares, err := m.ListShares(einstein, nil)
+	if err != nil {
+		t.Fatalf("error listing shares: %v", err)
+	}
+	for _, s := range shares {
+		if s.Id.OpaqueId == "2" {
+			t.Errorf("pruned share 2 is still listed")
+		}
+	}
+}
+
+func TestVerifySharesFailsOnStatErrors(t *testing.T) {
+	m := newTestManager(t)
+	if _, err := m.VerifyShares(context.Background(), failingStorage{}, true); err == nil {
+		t.Fatal("expected an error when the resources cannot be statted")
+	}
+	shares, _, err := m.Dump(context.Background())
+	if err != nil {
+		t.Fatalf("error dumping shares: %v", err)
+	}
+	if len(shares) != 3 {
+		t.Errorf("expected no share to be pruned, got %d shares", len(shares))
+	}
+}
+
+// failingStorage is a gateway that cannot stat any resource
+type failingStorage struct {
+	gateway.GatewayAPIClient
+}
+
+func (failingStorage) Stat(ctx context.Context, req *provider.StatRequest, opts ...grpc.CallOption) (*provider.StatResponse, error) {
+	return &provider.StatResponse{Status: &rpc.Status{Code: rpc.Code_CODE_INTERNAL, Message: "storage unavailable"}}, nil
+}
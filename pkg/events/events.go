@@ -0,0 +1,112 @@
+// Copyright 2018-2021 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+// Package events publishes events to interested parties, eg. to notify users
+// or to trigger post processing.
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/cs3org/reva/pkg/appctx"
+	"github.com/cs3org/reva/pkg/rhttp"
+	"github.com/pkg/errors"
+)
+
+// Event is the envelope for all published events.
+type Event struct {
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+// Publisher publishes events.
+type Publisher interface {
+	Publish(ctx context.Context, typ string, data interface{}) error
+}
+
+type webhook struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookPublisher returns a publisher that POSTs every event as JSON to the given url.
+func NewWebhookPublisher(url string, timeout time.Duration) Publisher {
+	return &webhook{
+		url:    url,
+		client: rhttp.GetHTTPClient(rhttp.Timeout(timeout)),
+	}
+}
+
+// Publish sends the event to the webhook.
+func (w *webhook) Publish(ctx context.Context, typ string, data interface{}) error {
+	body, err := json.Marshal(&Event{
+		Type: typ,
+		Time: time.Now(),
+		Data: data,
+	})
+	if err != nil {
+		return errors.Wrap(err, "events: error encoding event")
+	}
+
+	// do not use rhttp.NewRequest, we don't want to leak the reva token to the webhook
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "events: error creating request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := w.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "events: error sending event")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("events: webhook returned status code %d", res.StatusCode)
+	}
+	return nil
+}
+
+type async struct {
+	p Publisher
+}
+
+// NewAsyncPublisher returns a publisher that hands events to the given publisher in the
+// background, so that slow consumers do not delay the caller. Errors are only logged.
+func NewAsyncPublisher(p Publisher) Publisher {
+	return &async{p: p}
+}
+
+// Publish returns immediately and publishes the event in the background.
+func (a *async) Publish(ctx context.Context, typ string, data interface{}) error {
+	// the context of a request is cancelled when the request is done, only keep its logger
+	log := appctx.GetLogger(ctx)
+	bg := appctx.WithLogger(context.Background(), log)
+	go func() {
+		if err := a.p.Publish(bg, typ, data); err != nil {
+			log.Warn().Err(err).Str("type", typ).Msg("could not publish event")
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2018-2021 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package datatx
+
+import (
+	"context"
+	"path"
+
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/cs3org/reva/pkg/appctx"
+	"github.com/cs3org/reva/pkg/events"
+	"github.com/cs3org/reva/pkg/storage"
+	"github.com/cs3org/reva/pkg/user"
+	tusd "github.com/tus/tusd/pkg/handler"
+)
+
+// UploadFinishedEventType is the type of the event published when an upload has been finished
+const UploadFinishedEventType = "upload_finished"
+
+// UploadFinishedEvent notifies post processing services about a finished upload.
+// The resource id is the one known to the storage driver, it does not contain the storage id
+// of the storage provider.
+type UploadFinishedEvent struct {
+	ResourceID *provider.ResourceId `json:"resource_id"`
+	Path       string               `json:"path"`
+	Size       uint64               `json:"size"`
+	Executant  *userpb.UserId       `json:"executant"`
+}
+
+// EmitUploadFinishedEvent stats the uploaded file at the given path in the storage and publishes
+// an upload finished event. It is best effort, failures are only logged.
+func EmitUploadFinishedEvent(ctx context.Context, fs storage.FS, fn string, publisher events.Publisher) {
+	log := appctx.GetLogger(ctx)
+	info, err := fs.GetMD(ctx, &provider.Reference{Spec: &provider.Reference_Path{Path: fn}}, nil)
+	if err != nil {
+		log.Warn().Err(err).Str("path", fn).Msg("could not stat finished upload, not publishing event")
+		return
+	}
+	ev := &UploadFinishedEvent{
+		ResourceID: info.Id,
+		Path:       fn,
+		Size:       info.Size,
+	}
+	if u, ok := user.ContextGetUser(ctx); ok {
+		ev.Executant = u.Id
+	}
+	if err := publisher.Publish(ctx, UploadFinishedEventType, ev); err != nil {
+		log.Warn().Err(err).Str("path", fn).Msg("could not publish upload finished event")
+	}
+}
+
+// UploadPath returns the path in the storage the upload with the given info writes to.
+func UploadPath(info tusd.FileInfo) string {
+	return path.Join(info.MetaData["dir"], info.MetaData["filename"])
+}
+
+type uploadGetter interface {
+	GetUpload(ctx context.Context, id string) (tusd.Upload, error)
+}
+
+// SimpleUploadPath returns the path in the storage a simple upload to fn writes to. Storages that
+// create upload sessions expect the id of the session as fn, other storages write directly to fn.
+func SimpleUploadPath(ctx context.Context, fs storage.FS, fn string) string {
+	if g, ok := fs.(uploadGetter); ok {
+		if upload, err := g.GetUpload(ctx, fn); err == nil {
+			if info, err := upload.GetInfo(ctx); err == nil {
+				return UploadPath(info)
+			}
+		}
+	}
+	return fn
+}
@@ -18,11 +18,14 @@
 
 package registry
 
-import "github.com/cs3org/reva/pkg/rhttp/datatx"
+import (
+	"github.com/cs3org/reva/pkg/events"
+	"github.com/cs3org/reva/pkg/rhttp/datatx"
+)
 
 // NewFunc is the function that data transfer implementations
-// should register at init time.
-type NewFunc func(map[string]interface{}) (datatx.DataTX, error)
+// should register at init time. The publisher is nil if no events should be published.
+type NewFunc func(map[string]interface{}, events.Publisher) (datatx.DataTX, error)
 
 // NewFuncs is a map containing all the registered data transfers.
 var NewFuncs = map[string]NewFunc{}
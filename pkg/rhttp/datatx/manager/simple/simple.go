@@ -24,6 +24,7 @@ import (
 	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
 	"github.com/cs3org/reva/pkg/appctx"
 	"github.com/cs3org/reva/pkg/errtypes"
+	"github.com/cs3org/reva/pkg/events"
 	"github.com/cs3org/reva/pkg/rhttp/datatx"
 	"github.com/cs3org/reva/pkg/rhttp/datatx/manager/registry"
 	"github.com/cs3org/reva/pkg/rhttp/datatx/utils/download"
@@ -39,7 +40,8 @@ func init() {
 type config struct{}
 
 type manager struct {
-	conf *config
+	conf      *config
+	publisher events.Publisher
 }
 
 func parseConfig(m map[string]interface{}) (*config, error) {
@@ -52,13 +54,13 @@ func parseConfig(m map[string]interface{}) (*config, error) {
 }
 
 // New returns a datatx manager implementation that relies on HTTP PUT/GET.
-func New(m map[string]interface{}) (datatx.DataTX, error) {
+func New(m map[string]interface{}, publisher events.Publisher) (datatx.DataTX, error) {
 	c, err := parseConfig(m)
 	if err != nil {
 		return nil, err
 	}
 
-	return &manager{conf: c}, nil
+	return &manager{conf: c, publisher: publisher}, nil
 }
 
 func (m *manager) Handler(fs storage.FS) (http.Handler, error) {
@@ -75,9 +77,18 @@ func (m *manager) Handler(fs storage.FS) (http.Handler, error) {
 
 			ref := &provider.Reference{Spec: &provider.Reference_Path{Path: fn}}
 
+			// the upload session is gone after the upload, look up the target before
+			var target string
+			if m.publisher != nil {
+				target = datatx.SimpleUploadPath(ctx, fs, fn)
+			}
+
 			err := fs.Upload(ctx, ref, r.Body)
 			switch v := err.(type) {
 			case nil:
+				if m.publisher != nil {
+					datatx.EmitUploadFinishedEvent(ctx, fs, target, m.publisher)
+				}
 				w.WriteHeader(http.StatusOK)
 			case errtypes.PartialContent:
 				w.WriteHeader(http.StatusPartialContent)
@@ -0,0 +1,94 @@
+// Copyright 2018-2021 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package simple
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/cs3org/reva/pkg/rhttp/datatx"
+	"github.com/cs3org/reva/pkg/storage/fs/local"
+	"github.com/cs3org/reva/pkg/user"
+)
+
+// channelPublisher passes all published events to a channel
+type channelPublisher chan interface{}
+
+func (p channelPublisher) Publish(ctx context.Context, typ string, data interface{}) error {
+	if typ == datatx.UploadFinishedEventType {
+		p <- data
+	}
+	return nil
+}
+
+func TestFinishedUploadPublishesEvent(t *testing.T) {
+	fs, err := local.New(map[string]interface{}{"root": t.TempDir()})
+	if err != nil {
+		t.Fatalf("error creating storage: %v", err)
+	}
+	einstein := &userpb.User{Id: &userpb.UserId{Idp: "idp", OpaqueId: "einstein"}, Username: "einstein"}
+	ctx := user.ContextSetUser(context.Background(), einstein)
+
+	ids, err := fs.InitiateUpload(ctx, &provider.Reference{Spec: &provider.Reference_Path{Path: "/file.txt"}}, 5, nil)
+	if err != nil {
+		t.Fatalf("error initiating upload: %v", err)
+	}
+
+	p := make(channelPublisher, 2)
+	tx, err := New(nil, p)
+	if err != nil {
+		t.Fatalf("error creating simple datatx: %v", err)
+	}
+	h, err := tx.Handler(fs)
+	if err != nil {
+		t.Fatalf("error creating handler: %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodPut, "/"+ids["simple"], strings.NewReader("hello")).WithContext(ctx)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT returned %d", w.Code)
+	}
+
+	select {
+	case data := <-p:
+		ev, ok := data.(*datatx.UploadFinishedEvent)
+		if !ok {
+			t.Fatalf("unexpected event %+v", data)
+		}
+		if ev.Path != "/file.txt" || ev.Size != 5 || ev.ResourceID == nil || ev.Executant.GetOpaqueId() != "einstein" {
+			t.Errorf("unexpected upload finished event %+v", ev)
+		}
+	default:
+		t.Fatal("no upload finished event was published")
+	}
+
+	select {
+	case data := <-p:
+		t.Errorf("upload finished event was published twice, got %+v", data)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
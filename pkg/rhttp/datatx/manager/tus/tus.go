@@ -19,15 +19,21 @@
 package tus
 
 import (
+	"context"
+	"encoding/json"
 	"net/http"
 
 	"github.com/pkg/errors"
 
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	"github.com/cs3org/reva/pkg/appctx"
 	"github.com/cs3org/reva/pkg/errtypes"
+	"github.com/cs3org/reva/pkg/events"
 	"github.com/cs3org/reva/pkg/rhttp/datatx"
 	"github.com/cs3org/reva/pkg/rhttp/datatx/manager/registry"
 	"github.com/cs3org/reva/pkg/rhttp/datatx/utils/download"
 	"github.com/cs3org/reva/pkg/storage"
+	"github.com/cs3org/reva/pkg/user"
 	"github.com/mitchellh/mapstructure"
 	tusd "github.com/tus/tusd/pkg/handler"
 )
@@ -39,7 +45,8 @@ func init() {
 type config struct{}
 
 type manager struct {
-	conf *config
+	conf      *config
+	publisher events.Publisher
 }
 
 func parseConfig(m map[string]interface{}) (*config, error) {
@@ -52,13 +59,13 @@ func parseConfig(m map[string]interface{}) (*config, error) {
 }
 
 // New returns a datatx manager implementation that relies on HTTP PUT/GET.
-func New(m map[string]interface{}) (datatx.DataTX, error) {
+func New(m map[string]interface{}, publisher events.Publisher) (datatx.DataTX, error) {
 	c, err := parseConfig(m)
 	if err != nil {
 		return nil, err
 	}
 
-	return &manager{conf: c}, nil
+	return &manager{conf: c, publisher: publisher}, nil
 }
 
 func (m *manager) Handler(fs storage.FS) (http.Handler, error) {
@@ -77,7 +84,8 @@ func (m *manager) Handler(fs storage.FS) (http.Handler, error) {
 	composable.UseIn(composer)
 
 	config := tusd.Config{
-		StoreComposer: composer,
+		StoreComposer:         composer,
+		NotifyCompleteUploads: m.publisher != nil,
 	}
 
 	handler, err := tusd.NewUnroutedHandler(config)
@@ -85,6 +93,14 @@ func (m *manager) Handler(fs storage.FS) (http.Handler, error) {
 		return nil, err
 	}
 
+	if m.publisher != nil {
+		go func() {
+			for ev := range handler.CompleteUploads {
+				go m.uploadFinished(fs, ev)
+			}
+		}()
+	}
+
 	h := handler.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
 		method := r.Method
@@ -103,6 +119,10 @@ func (m *manager) Handler(fs storage.FS) (http.Handler, error) {
 		case "DELETE":
 			handler.DelFile(w, r)
 		case "GET":
+			if lister, ok := fs.(uploadLister); ok && (r.URL.Path == "" || r.URL.Path == "/") {
+				listUploads(w, r, lister)
+				return
+			}
 			download.GetOrHeadFile(w, r, fs)
 		default:
 			w.WriteHeader(http.StatusNotImplemented)
@@ -112,6 +132,64 @@ func (m *manager) Handler(fs storage.FS) (http.Handler, error) {
 	return h, nil
 }
 
+// uploadFinished publishes an upload finished event on behalf of the user that created the upload.
+// The request of the final PATCH is gone, so the context is rebuilt from the upload info.
+func (m *manager) uploadFinished(fs storage.FS, ev tusd.HookEvent) {
+	info := ev.Upload
+	ctx := user.ContextSetUser(context.Background(), &userpb.User{
+		Id: &userpb.UserId{
+			Idp:      info.Storage["Idp"],
+			OpaqueId: info.Storage["UserId"],
+		},
+		Username: info.Storage["UserName"],
+	})
+	datatx.EmitUploadFinishedEvent(ctx, fs, datatx.UploadPath(info), m.publisher)
+}
+
+// upload describes an upload in progress, so clients can resume it
+type upload struct {
+	ID       string `json:"id"`
+	Filename string `json:"filename"`
+	Offset   int64  `json:"offset"`
+	Size     int64  `json:"size"`
+}
+
+// listUploads writes the uploads in progress the storage shows to the current user as json.
+// The storage decides which uploads the user may see.
+func listUploads(w http.ResponseWriter, r *http.Request, lister uploadLister) {
+	log := appctx.GetLogger(r.Context())
+	infos, err := lister.ListUploads(r.Context())
+	if err != nil {
+		switch err.(type) {
+		case errtypes.UserRequired:
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			log.Error().Err(err).Msg("error listing uploads")
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	uploads := make([]upload, 0, len(infos))
+	for _, info := range infos {
+		uploads = append(uploads, upload{
+			ID:       info.ID,
+			Filename: info.MetaData["filename"],
+			Offset:   info.Offset,
+			Size:     info.Size,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(uploads); err != nil {
+		log.Error().Err(err).Msg("error writing uploads")
+	}
+}
+
+// uploadLister is implemented by storages that can list the uploads in progress
+type uploadLister interface {
+	ListUploads(ctx context.Context) ([]tusd.FileInfo, error)
+}
+
 // Composable is the interface that a struct needs to implement
 // to be composable, so that it can support the TUS methods
 type composable interface {
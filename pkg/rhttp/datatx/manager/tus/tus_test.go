@@ -0,0 +1,179 @@
+// Copyright 2018-2021 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package tus
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/cs3org/reva/pkg/errtypes"
+	"github.com/cs3org/reva/pkg/rhttp/datatx"
+	"github.com/cs3org/reva/pkg/storage"
+	"github.com/cs3org/reva/pkg/storage/fs/local"
+	"github.com/cs3org/reva/pkg/user"
+	tusd "github.com/tus/tusd/pkg/handler"
+)
+
+// channelPublisher passes all published events to a channel
+type channelPublisher chan interface{}
+
+func (p channelPublisher) Publish(ctx context.Context, typ string, data interface{}) error {
+	if typ == datatx.UploadFinishedEventType {
+		p <- data
+	}
+	return nil
+}
+
+func TestFinishedUploadPublishesEvent(t *testing.T) {
+	fs, err := local.New(map[string]interface{}{"root": t.TempDir()})
+	if err != nil {
+		t.Fatalf("error creating storage: %v", err)
+	}
+	einstein := &userpb.User{Id: &userpb.UserId{Idp: "idp", OpaqueId: "einstein"}, Username: "einstein"}
+	ctx := user.ContextSetUser(context.Background(), einstein)
+
+	ids, err := fs.InitiateUpload(ctx, &provider.Reference{Spec: &provider.Reference_Path{Path: "/file.txt"}}, 5, nil)
+	if err != nil {
+		t.Fatalf("error initiating upload: %v", err)
+	}
+
+	p := make(channelPublisher, 2)
+	tx, err := New(nil, p)
+	if err != nil {
+		t.Fatalf("error creating tus datatx: %v", err)
+	}
+	h, err := tx.Handler(fs)
+	if err != nil {
+		t.Fatalf("error creating handler: %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodPatch, "/"+ids["tus"], strings.NewReader("hello")).WithContext(ctx)
+	r.Header.Set("Tus-Resumable", "1.0.0")
+	r.Header.Set("Upload-Offset", "0")
+	r.Header.Set("Content-Type", "application/offset+octet-stream")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("PATCH returned %d: %s", w.Code, w.Body.String())
+	}
+
+	select {
+	case data := <-p:
+		ev, ok := data.(*datatx.UploadFinishedEvent)
+		if !ok {
+			t.Fatalf("unexpected event %+v", data)
+		}
+		if ev.Path != "/file.txt" || ev.Size != 5 || ev.ResourceID == nil || ev.Executant.GetOpaqueId() != "einstein" {
+			t.Errorf("unexpected upload finished event %+v", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no upload finished event was published")
+	}
+
+	select {
+	case data := <-p:
+		t.Errorf("upload finished event was published twice, got %+v", data)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// listingFS lists the given uploads for einstein only, like a storage checking the permissions of the user
+type listingFS struct {
+	storage.FS
+	uploads []tusd.FileInfo
+}
+
+func (fs listingFS) UseIn(composer *tusd.StoreComposer) {
+	fs.FS.(composable).UseIn(composer)
+}
+
+func (fs listingFS) ListUploads(ctx context.Context) ([]tusd.FileInfo, error) {
+	u, ok := user.ContextGetUser(ctx)
+	if !ok {
+		return nil, errtypes.UserRequired("no user in context")
+	}
+	if u.Id.OpaqueId != "einstein" {
+		return []tusd.FileInfo{}, nil
+	}
+	return fs.uploads, nil
+}
+
+func TestListUploads(t *testing.T) {
+	local, err := local.New(map[string]interface{}{"root": t.TempDir()})
+	if err != nil {
+		t.Fatalf("error creating storage: %v", err)
+	}
+	fs := listingFS{FS: local, uploads: []tusd.FileInfo{
+		{ID: "1", Size: 10, Offset: 5, MetaData: tusd.MetaData{"filename": "a.txt"}},
+		{ID: "2", Size: 20, MetaData: tusd.MetaData{"filename": "b.txt"}},
+	}}
+	tx, err := New(nil, nil)
+	if err != nil {
+		t.Fatalf("error creating tus datatx: %v", err)
+	}
+	h, err := tx.Handler(fs)
+	if err != nil {
+		t.Fatalf("error creating handler: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		user     *userpb.User
+		code     int
+		expected []upload
+	}{
+		{"manager", &userpb.User{Id: &userpb.UserId{Idp: "idp", OpaqueId: "einstein"}}, http.StatusOK, []upload{
+			{ID: "1", Filename: "a.txt", Offset: 5, Size: 10},
+			{ID: "2", Filename: "b.txt", Size: 20},
+		}},
+		{"other user", &userpb.User{Id: &userpb.UserId{Idp: "idp", OpaqueId: "marie"}}, http.StatusOK, []upload{}},
+		{"anonymous", nil, http.StatusUnauthorized, nil},
+	}
+
+	for _, tt := range tests {
+		ctx := context.Background()
+		if tt.user != nil {
+			ctx = user.ContextSetUser(ctx, tt.user)
+		}
+		r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.code {
+			t.Fatalf("%s: listing uploads returned %d instead of %d", tt.name, w.Code, tt.code)
+		}
+		if tt.code != http.StatusOK {
+			continue
+		}
+		uploads := []upload{}
+		if err := json.Unmarshal(w.Body.Bytes(), &uploads); err != nil {
+			t.Fatalf("%s: error decoding uploads %q: %v", tt.name, w.Body.String(), err)
+		}
+		if !reflect.DeepEqual(uploads, tt.expected) {
+			t.Errorf("%s: listed uploads %+v instead of %+v", tt.name, uploads, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2018-2021 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package share
+
+import (
+	"bytes"
+	"path"
+	"strings"
+	"text/template"
+
+	collaboration "github.com/cs3org/go-cs3apis/cs3/sharing/collaboration/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/cs3org/reva/pkg/errtypes"
+	"github.com/pkg/errors"
+)
+
+// jailData is passed to the share jail template to build the name of an accepted share.
+type jailData struct {
+	Name    string
+	ShareID string
+	Owner   string
+}
+
+// ParseJailTemplate parses the template used to name accepted shares in the share folder of the recipient.
+// An empty template returns nil, which mounts shares with the name of the shared resource.
+func ParseJailTemplate(tpl string) (*template.Template, error) {
+	if tpl == "" {
+		return nil, nil
+	}
+	t, err := template.New("shareJail").Parse(tpl)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing share jail template")
+	}
+	return t, nil
+}
+
+// MountName returns the name an accepted share is mounted with in the share folder of the recipient.
+// Storage providers only allow references as direct children of the share folder, so the name
+// must be a single path segment.
+func MountName(t *template.Template, s *collaboration.Share, info *provider.ResourceInfo) (string, error) {
+	name := path.Base(info.GetPath())
+	if t == nil {
+		return name, nil
+	}
+
+	var b bytes.Buffer
+	err := t.Execute(&b, jailData{
+		Name:    name,
+		ShareID: s.GetId().GetOpaqueId(),
+		Owner:   s.GetOwner().GetOpaqueId(),
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "error executing share jail template")
+	}
+
+	name = b.String()
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return "", errtypes.BadRequest("share jail template must produce a single path segment, got: " + name)
+	}
+	return name, nil
+}
@@ -0,0 +1,73 @@
+// Copyright 2018-2021 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package share
+
+import (
+	"testing"
+
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	collaboration "github.com/cs3org/go-cs3apis/cs3/sharing/collaboration/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+)
+
+func TestMountName(t *testing.T) {
+	s := &collaboration.Share{
+		Id:    &collaboration.ShareId{OpaqueId: "share-1"},
+		Owner: &userpb.UserId{OpaqueId: "einstein"},
+	}
+	info := &provider.ResourceInfo{Path: "/home/einstein/Projects/reva"}
+
+	tests := []struct {
+		template string
+		expected string
+		err      bool
+	}{
+		{"", "reva", false},
+		{"{{.Name}} ({{.ShareID}})", "reva (share-1)", false},
+		{"{{.Owner}} - {{.Name}}", "einstein - reva", false},
+		{"{{.Owner}}/{{.Name}}", "", true},
+		{"{{.Missing}}", "", true},
+	}
+
+	for _, tt := range tests {
+		tpl, err := ParseJailTemplate(tt.template)
+		if err != nil {
+			t.Fatalf("ParseJailTemplate(%q) failed: %v", tt.template, err)
+		}
+		name, err := MountName(tpl, s, info)
+		if tt.err {
+			if err == nil {
+				t.Errorf("MountName with template %q returned %q instead of an error", tt.template, name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("MountName with template %q failed: %v", tt.template, err)
+		}
+		if name != tt.expected {
+			t.Errorf("MountName with template %q returned %q instead of expected %q", tt.template, name, tt.expected)
+		}
+	}
+}
+
+func TestParseJailTemplateFailsOnInvalidTemplate(t *testing.T) {
+	if _, err := ParseJailTemplate("{{.Name"); err == nil {
+		t.Error("expected an error for an invalid template")
+	}
+}
@@ -381,7 +381,7 @@ func (m *mgr) ListShares(ctx context.Context, filters []*collaboration.ListShare
 }
 
 // we list the shares that are targeted to the user in context or to the user groups.
-func (m *mgr) ListReceivedShares(ctx context.Context) ([]*collaboration.ReceivedShare, error) {
+func (m *mgr) ListReceivedShares(ctx context.Context, filters []*collaboration.ListSharesRequest_Filter) ([]*collaboration.ReceivedShare, error) {
 	var rss []*collaboration.ReceivedShare
 	m.Lock()
 	defer m.Unlock()
@@ -391,6 +391,9 @@ func (m *mgr) ListReceivedShares(ctx context.Context) ([]*collaboration.Received
 			// omit shares created by me
 			continue
 		}
+		if !matchesFilters(s, filters) {
+			continue
+		}
 		if s.Grantee.Type == provider.GranteeType_GRANTEE_TYPE_USER && utils.UserEqual(user.Id, s.Grantee.GetUserId()) {
 			rs := m.convert(ctx, s)
 			rss = append(rss, rs)
@@ -407,6 +410,16 @@ func (m *mgr) ListReceivedShares(ctx context.Context) ([]*collaboration.Received
 	return rss, nil
 }
 
+// matchesFilters checks if the share matches the given filters. Only resource id filters are supported.
+func matchesFilters(s *collaboration.Share, filters []*collaboration.ListSharesRequest_Filter) bool {
+	for _, f := range filters {
+		if f.Type == collaboration.ListSharesRequest_Filter_TYPE_RESOURCE_ID && !utils.ResourceEqual(s.ResourceId, f.GetResourceId()) {
+			return false
+		}
+	}
+	return true
+}
+
 // convert must be called in a lock-controlled block.
 func (m *mgr) convert(ctx context.Context, s *collaboration.Share) *collaboration.ReceivedShare {
 	rs := &collaboration.ReceivedShare{
@@ -476,3 +489,36 @@ func (m *mgr) UpdateReceivedShare(ctx context.Context, ref *collaboration.ShareR
 	rs.State = f.GetState()
 	return rs, nil
 }
+
+// Load imports the given shares and received share states, keeping the share ids.
+// Shares with the id of an existing share replace it.
+func (m *mgr) Load(ctx context.Context, shares []*collaboration.Share, states []share.ReceivedShareWithUser) error {
+	m.Lock()
+	defer m.Unlock()
+
+	index := make(map[string]int, len(m.model.Shares))
+	for i, s := range m.model.Shares {
+		index[s.GetId().GetOpaqueId()] = i
+	}
+	for _, s := range shares {
+		if i, ok := index[s.GetId().GetOpaqueId()]; ok {
+			m.model.Shares[i] = s
+			continue
+		}
+		index[s.GetId().GetOpaqueId()] = len(m.model.Shares)
+		m.model.Shares = append(m.model.Shares, s)
+	}
+
+	for _, st := range states {
+		uid := st.UserID.String()
+		if m.model.State[uid] == nil {
+			m.model.State[uid] = map[string]collaboration.ShareState{}
+		}
+		m.model.State[uid][st.ReceivedShare.GetShare().GetId().String()] = st.ReceivedShare.GetState()
+	}
+
+	if err := m.model.Save(); err != nil {
+		return errors.Wrap(err, "error saving model")
+	}
+	return nil
+}
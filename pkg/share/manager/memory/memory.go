@@ -242,7 +242,7 @@ func (m *manager) ListShares(ctx context.Context, filters []*collaboration.ListS
 }
 
 // we list the shares that are targeted to the user in context or to the user groups.
-func (m *manager) ListReceivedShares(ctx context.Context) ([]*collaboration.ReceivedShare, error) {
+func (m *manager) ListReceivedShares(ctx context.Context, filters []*collaboration.ListSharesRequest_Filter) ([]*collaboration.ReceivedShare, error) {
 	var rss []*collaboration.ReceivedShare
 	m.lock.Lock()
 	defer m.lock.Unlock()
@@ -252,6 +252,9 @@ func (m *manager) ListReceivedShares(ctx context.Context) ([]*collaboration.Rece
 			// omit shares created by me
 			continue
 		}
+		if !matchesFilters(s, filters) {
+			continue
+		}
 		if s.Grantee.Type == provider.GranteeType_GRANTEE_TYPE_USER && utils.UserEqual(user.Id, s.Grantee.GetUserId()) {
 			rs := m.convert(ctx, s)
 			rss = append(rss, rs)
@@ -268,6 +271,16 @@ func (m *manager) ListReceivedShares(ctx context.Context) ([]*collaboration.Rece
 	return rss, nil
 }
 
+// matchesFilters checks if the share matches the given filters. Only resource id filters are supported.
+func matchesFilters(s *collaboration.Share, filters []*collaboration.ListSharesRequest_Filter) bool {
+	for _, f := range filters {
+		if f.Type == collaboration.ListSharesRequest_Filter_TYPE_RESOURCE_ID && !utils.ResourceEqual(s.ResourceId, f.GetResourceId()) {
+			return false
+		}
+	}
+	return true
+}
+
 // convert must be called in a lock-controlled block.
 func (m *manager) convert(ctx context.Context, s *collaboration.Share) *collaboration.ReceivedShare {
 	rs := &collaboration.ReceivedShare{
@@ -0,0 +1,39 @@
+// Copyright 2018-2021 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package share
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// Migrate copies all shares and received share states from one share manager to another.
+// Share ids are kept and shares already present in the target are replaced, so an interrupted
+// migration can be run again.
+func Migrate(ctx context.Context, from DumpableManager, to LoadableManager) error {
+	shares, states, err := from.Dump(ctx)
+	if err != nil {
+		return errors.Wrap(err, "error dumping shares")
+	}
+	if err := to.Load(ctx, shares, states); err != nil {
+		return errors.Wrap(err, "error loading shares")
+	}
+	return nil
+}
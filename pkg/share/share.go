@@ -21,6 +21,8 @@ package share
 import (
 	"context"
 
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
 	collaboration "github.com/cs3org/go-cs3apis/cs3/sharing/collaboration/v1beta1"
 	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
 )
@@ -43,8 +45,9 @@ type Manager interface {
 	// it returns only shares attached to the given resource.
 	ListShares(ctx context.Context, filters []*collaboration.ListSharesRequest_Filter) ([]*collaboration.Share, error)
 
-	// ListReceivedShares returns the list of shares the user has access.
-	ListReceivedShares(ctx context.Context) ([]*collaboration.ReceivedShare, error)
+	// ListReceivedShares returns the list of shares the user has access. If filters are provided,
+	// it returns only shares matching them.
+	ListReceivedShares(ctx context.Context, filters []*collaboration.ListSharesRequest_Filter) ([]*collaboration.ReceivedShare, error)
 
 	// GetReceivedShare returns the information for a received share the user has access.
 	GetReceivedShare(ctx context.Context, ref *collaboration.ShareReference) (*collaboration.ReceivedShare, error)
@@ -52,3 +55,31 @@ type Manager interface {
 	// UpdateReceivedShare updates the received share with share state.
 	UpdateReceivedShare(ctx context.Context, ref *collaboration.ShareReference, f *collaboration.UpdateReceivedShareRequest_UpdateField) (*collaboration.ReceivedShare, error)
 }
+
+// ReceivedShareWithUser is the state a recipient has set for a received share.
+type ReceivedShareWithUser struct {
+	UserID        *userpb.UserId
+	ReceivedShare *collaboration.ReceivedShare
+}
+
+// DumpableManager is implemented by share managers that can return all of their shares,
+// regardless of the user in the context.
+type DumpableManager interface {
+	// Dump returns all shares and the states the recipients have set for them.
+	Dump(ctx context.Context) ([]*collaboration.Share, []ReceivedShareWithUser, error)
+}
+
+// LoadableManager is implemented by share managers that can import shares keeping their ids.
+type LoadableManager interface {
+	// Load stores the given shares and received share states. Shares with the id of an
+	// existing share replace it, so loading the same shares again is a no-op.
+	Load(ctx context.Context, shares []*collaboration.Share, states []ReceivedShareWithUser) error
+}
+
+// VerifiableManager is implemented by share managers that can check their shares against the storage.
+type VerifiableManager interface {
+	// VerifyShares stats the resource of every share with the given gateway client and returns
+	// the shares whose resource does not exist anymore. If prune is set, these shares are removed.
+	// The context must be allowed to stat all shared resources.
+	VerifyShares(ctx context.Context, client gateway.GatewayAPIClient, prune bool) ([]*collaboration.Share, error)
+}
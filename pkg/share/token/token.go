@@ -0,0 +1,133 @@
+// Copyright 2018-2021 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+// Package token mints and resolves capability tokens for internal shares.
+// A token identifies a share and its grantee.
+package token
+
+import (
+	"time"
+
+	grouppb "github.com/cs3org/go-cs3apis/cs3/identity/group/v1beta1"
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	collaboration "github.com/cs3org/go-cs3apis/cs3/sharing/collaboration/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/cs3org/reva/pkg/errtypes"
+	"github.com/cs3org/reva/pkg/utils"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// Audience is the audience of share tokens. It differs from the audience of user
+// tokens so that one cannot be used in place of the other.
+const Audience = "reva-share"
+
+// Manager mints and resolves share tokens.
+type Manager struct {
+	secret  []byte
+	expires time.Duration
+}
+
+// claims are custom claims for the JWT token.
+type claims struct {
+	jwt.StandardClaims
+	ShareID     string               `json:"share_id"`
+	Owner       *userpb.UserId       `json:"owner"`
+	GranteeType provider.GranteeType `json:"grantee_type"`
+	UserID      *userpb.UserId       `json:"user_id,omitempty"`
+	GroupID     *grouppb.GroupId     `json:"group_id,omitempty"`
+}
+
+// New returns a new token manager signing tokens with the given secret. The tokens
+// expire after the given duration.
+func New(secret string, expires time.Duration) (*Manager, error) {
+	if secret == "" {
+		return nil, errors.New("token: secret for signing share tokens is not defined")
+	}
+	if expires <= 0 {
+		return nil, errors.New("token: share tokens need an expiration")
+	}
+	return &Manager{secret: []byte(secret), expires: expires}, nil
+}
+
+// MintToken creates a token for the given share and its grantee.
+func (m *Manager) MintToken(s *collaboration.Share) (string, error) {
+	if s.GetId() == nil || s.GetGrantee() == nil || s.GetOwner() == nil {
+		return "", errors.New("token: share id, owner and grantee are required")
+	}
+	uid, gid := utils.ExtractGranteeID(s.Grantee)
+	now := time.Now()
+	c := claims{
+		StandardClaims: jwt.StandardClaims{
+			Audience:  Audience,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(m.expires).Unix(),
+		},
+		ShareID:     s.Id.OpaqueId,
+		Owner:       s.Owner,
+		GranteeType: s.Grantee.Type,
+		UserID:      uid,
+		GroupID:     gid,
+	}
+
+	t := jwt.NewWithClaims(jwt.GetSigningMethod("HS256"), c)
+	tkn, err := t.SignedString(m.secret)
+	if err != nil {
+		return "", errors.Wrapf(err, "error signing token for share %s", s.Id.OpaqueId)
+	}
+	return tkn, nil
+}
+
+// ResolveToken returns the share the token has been minted for. Only the id, the
+// owner and the grantee of the returned share are set, callers have to look the
+// share up to make sure it has not been removed since.
+func (m *Manager) ResolveToken(tkn string) (*collaboration.Share, error) {
+	token, err := jwt.ParseWithClaims(tkn, &claims{}, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return m.secret, nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing token")
+	}
+
+	c, ok := token.Claims.(*claims)
+	if !ok || !token.Valid || !c.VerifyAudience(Audience, true) || c.ExpiresAt == 0 {
+		return nil, errtypes.InvalidCredentials("invalid share token")
+	}
+	if c.Owner == nil {
+		return nil, errtypes.InvalidCredentials("share token without owner")
+	}
+
+	grantee := &provider.Grantee{Type: c.GranteeType}
+	switch {
+	case c.GranteeType == provider.GranteeType_GRANTEE_TYPE_USER && c.UserID != nil:
+		grantee.Id = &provider.Grantee_UserId{UserId: c.UserID}
+	case c.GranteeType == provider.GranteeType_GRANTEE_TYPE_GROUP && c.GroupID != nil:
+		grantee.Id = &provider.Grantee_GroupId{GroupId: c.GroupID}
+	default:
+		return nil, errtypes.InvalidCredentials("share token without grantee")
+	}
+
+	return &collaboration.Share{
+		Id:      &collaboration.ShareId{OpaqueId: c.ShareID},
+		Owner:   c.Owner,
+		Grantee: grantee,
+	}, nil
+}
@@ -0,0 +1,161 @@
+// Copyright 2018-2021 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package token
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	grouppb "github.com/cs3org/go-cs3apis/cs3/identity/group/v1beta1"
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	collaboration "github.com/cs3org/go-cs3apis/cs3/sharing/collaboration/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	jwtmanager "github.com/cs3org/reva/pkg/token/manager/jwt"
+	"github.com/cs3org/reva/pkg/utils"
+	"github.com/dgrijalva/jwt-go"
+)
+
+var owner = &userpb.UserId{Idp: "http://localhost:20080", OpaqueId: "f7fbf8c8-139b-4376-b307-cf0a8c2d0d9c"}
+
+func TestMintAndResolveToken(t *testing.T) {
+	m, err := New("secret", time.Hour)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	shares := []*collaboration.Share{
+		{
+			Id:    &collaboration.ShareId{OpaqueId: "user-share"},
+			Owner: owner,
+			Grantee: &provider.Grantee{
+				Type: provider.GranteeType_GRANTEE_TYPE_USER,
+				Id: &provider.Grantee_UserId{UserId: &userpb.UserId{
+					Idp:      "http://localhost:20080",
+					OpaqueId: "4c510ada-c86b-4815-8820-42cdf82c3d51",
+				}},
+			},
+		},
+		{
+			Id:    &collaboration.ShareId{OpaqueId: "group-share"},
+			Owner: owner,
+			Grantee: &provider.Grantee{
+				Type: provider.GranteeType_GRANTEE_TYPE_GROUP,
+				Id:   &provider.Grantee_GroupId{GroupId: &grouppb.GroupId{OpaqueId: "physics-lovers"}},
+			},
+		},
+	}
+
+	for _, s := range shares {
+		tkn, err := m.MintToken(s)
+		if err != nil {
+			t.Fatalf("MintToken() error = %v", err)
+		}
+
+		resolved, err := m.ResolveToken(tkn)
+		if err != nil {
+			t.Fatalf("ResolveToken() error = %v", err)
+		}
+		if resolved.Id.OpaqueId != s.Id.OpaqueId {
+			t.Errorf("ResolveToken() got share id %s, expected %s", resolved.Id.OpaqueId, s.Id.OpaqueId)
+		}
+		if !utils.UserEqual(resolved.Owner, s.Owner) {
+			t.Errorf("ResolveToken() got owner %v, expected %v", resolved.Owner, s.Owner)
+		}
+		if !utils.GranteeEqual(resolved.Grantee, s.Grantee) {
+			t.Errorf("ResolveToken() got grantee %v, expected %v", resolved.Grantee, s.Grantee)
+		}
+	}
+}
+
+func TestResolveTokenWithWrongSecret(t *testing.T) {
+	m, _ := New("secret", time.Hour)
+	other, _ := New("other", time.Hour)
+
+	tkn, err := m.MintToken(&collaboration.Share{
+		Id:    &collaboration.ShareId{OpaqueId: "user-share"},
+		Owner: owner,
+		Grantee: &provider.Grantee{
+			Type: provider.GranteeType_GRANTEE_TYPE_USER,
+			Id:   &provider.Grantee_UserId{UserId: &userpb.UserId{OpaqueId: "einstein"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("MintToken() error = %v", err)
+	}
+
+	if _, err := other.ResolveToken(tkn); err == nil {
+		t.Errorf("ResolveToken() resolved a token signed with a different secret")
+	}
+}
+
+func TestResolveExpiredToken(t *testing.T) {
+	m, _ := New("secret", time.Nanosecond)
+
+	tkn, err := m.MintToken(&collaboration.Share{
+		Id:    &collaboration.ShareId{OpaqueId: "user-share"},
+		Owner: owner,
+		Grantee: &provider.Grantee{
+			Type: provider.GranteeType_GRANTEE_TYPE_USER,
+			Id:   &provider.Grantee_UserId{UserId: &userpb.UserId{OpaqueId: "einstein"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("MintToken() error = %v", err)
+	}
+
+	time.Sleep(time.Second)
+	if _, err := m.ResolveToken(tkn); err == nil {
+		t.Errorf("ResolveToken() resolved an expired token")
+	}
+}
+
+func TestShareAndUserTokensAreNotInterchangeable(t *testing.T) {
+	m, _ := New("secret", time.Hour)
+	users, err := jwtmanager.New(map[string]interface{}{"secret": "secret"})
+	if err != nil {
+		t.Fatalf("jwt.New() error = %v", err)
+	}
+
+	userToken, err := users.MintToken(context.Background(), &userpb.User{Id: owner}, nil)
+	if err != nil {
+		t.Fatalf("MintToken() error = %v", err)
+	}
+	if _, err := m.ResolveToken(userToken); err == nil {
+		t.Errorf("ResolveToken() resolved a user token")
+	}
+}
+
+func TestResolveTokenRequiresHMAC(t *testing.T) {
+	m, _ := New("secret", time.Hour)
+
+	tkn, err := jwt.NewWithClaims(jwt.SigningMethodNone, claims{
+		StandardClaims: jwt.StandardClaims{Audience: Audience, ExpiresAt: time.Now().Add(time.Hour).Unix()},
+		ShareID:        "user-share",
+		Owner:          owner,
+		GranteeType:    provider.GranteeType_GRANTEE_TYPE_USER,
+		UserID:         &userpb.UserId{OpaqueId: "einstein"},
+	}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatalf("SignedString() error = %v", err)
+	}
+	if _, err := m.ResolveToken(tkn); err == nil {
+		t.Errorf("ResolveToken() resolved an unsigned token")
+	}
+}
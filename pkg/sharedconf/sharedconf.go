@@ -28,9 +28,10 @@ import (
 var sharedConf = &conf{}
 
 type conf struct {
-	JWTSecret   string `mapstructure:"jwt_secret"`
-	GatewaySVC  string `mapstructure:"gatewaysvc"`
-	DataGateway string `mapstructure:"datagateway"`
+	JWTSecret         string `mapstructure:"jwt_secret"`
+	GatewaySVC        string `mapstructure:"gatewaysvc"`
+	DataGateway       string `mapstructure:"datagateway"`
+	ShareJailTemplate string `mapstructure:"share_jail_template"`
 }
 
 // Decode decodes the configuration.
@@ -86,3 +87,11 @@ func GetDataGateway(val string) string {
 	}
 	return val
 }
+
+// GetShareJailTemplate returns the package level share jail template if not overwritten.
+func GetShareJailTemplate(val string) string {
+	if val == "" {
+		return sharedConf.ShareJailTemplate
+	}
+	return val
+}
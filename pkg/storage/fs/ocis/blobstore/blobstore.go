@@ -23,6 +23,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -78,6 +79,29 @@ func (bs *Blobstore) Delete(key string) error {
 	return nil
 }
 
+// List returns the keys of all blobs in the blobstore with the time they were last modified
+func (bs *Blobstore) List() (map[string]time.Time, error) {
+	blobs := map[string]time.Time{}
+	err := filepath.Walk(bs.root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		key, err := filepath.Rel(bs.root, p)
+		if err != nil {
+			return err
+		}
+		blobs[filepath.ToSlash(key)] = info.ModTime()
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list blobs")
+	}
+	return blobs, nil
+}
+
 func (bs *Blobstore) path(key string) string {
 	return filepath.Join(bs.root, filepath.Clean(filepath.Join("/", key)))
 }
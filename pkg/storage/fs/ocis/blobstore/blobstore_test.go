@@ -101,6 +101,15 @@ var _ = Describe("Blobstore", func() {
 			})
 		})
 
+		Describe("List", func() {
+			It("returns the keys of all blobs", func() {
+				blobs, err := bs.List()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(blobs).To(HaveLen(1))
+				Expect(blobs).To(HaveKey(key))
+			})
+		})
+
 		Describe("Delete", func() {
 			It("deletes the blob", func() {
 				_, err := os.Stat(blobPath)
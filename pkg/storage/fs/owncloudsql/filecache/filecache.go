@@ -30,7 +30,7 @@ import (
 	"time"
 
 	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
-	conversions "github.com/cs3org/reva/internal/http/services/owncloud/ocs/conversions"
+	conversions "github.com/cs3org/reva/pkg/conversions"
 
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
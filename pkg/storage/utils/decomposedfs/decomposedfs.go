@@ -29,8 +29,10 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	types "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
 	"github.com/cs3org/reva/pkg/appctx"
 	"github.com/cs3org/reva/pkg/errtypes"
 	"github.com/cs3org/reva/pkg/logger"
@@ -73,6 +75,11 @@ type Tree interface {
 	Propagate(ctx context.Context, node *node.Node) (err error)
 }
 
+// orphanScanner is implemented by trees that can find the blobs no node refers to anymore
+type orphanScanner interface {
+	OrphanedBlobs(gracePeriod time.Duration, purge bool) ([]string, error)
+}
+
 // Decomposedfs provides the base for decomposed filesystem implementations
 type Decomposedfs struct {
 	lu           *Lookup
@@ -80,6 +87,7 @@ type Decomposedfs struct {
 	o            *options.Options
 	p            PermissionsChecker
 	chunkHandler *chunking.ChunkHandler
+	stopScan     chan struct{}
 }
 
 // NewDefault returns an instance with default components
@@ -95,6 +103,7 @@ func NewDefault(m map[string]interface{}, bs tree.Blobstore) (storage.FS, error)
 	lu.Options = o
 
 	tp := tree.New(o.Root, o.TreeTimeAccounting, o.TreeSizeAccounting, lu, bs)
+	tp.SetPropagationBatchWindow(time.Duration(o.PropagationBatchWindow) * time.Millisecond)
 	return New(o, lu, p, tp)
 }
 
@@ -108,17 +117,58 @@ func New(o *options.Options, lu *Lookup, p PermissionsChecker, tp Tree) (storage
 		return nil, errors.Wrap(err, "could not setup tree")
 	}
 
-	return &Decomposedfs{
+	if err := os.MkdirAll(o.UploadDirectory, 0700); err != nil {
+		return nil, errors.Wrap(err, "could not create upload directory")
+	}
+
+	fs := &Decomposedfs{
 		tp:           tp,
 		lu:           lu,
 		o:            o,
 		p:            p,
-		chunkHandler: chunking.NewChunkHandler(filepath.Join(o.Root, "uploads")),
-	}, nil
+		chunkHandler: chunking.NewChunkHandler(o.UploadDirectory),
+	}
+
+	if o.OrphanedBlobsScanInterval > 0 {
+		if scanner, ok := tp.(orphanScanner); ok {
+			fs.stopScan = make(chan struct{})
+			go fs.scanOrphanedBlobs(scanner, time.Duration(o.OrphanedBlobsScanInterval)*time.Second)
+		} else {
+			logger.New().Warn().Msg("tree can not scan for orphaned blobs, not scanning")
+		}
+	}
+
+	return fs, nil
+}
+
+// scanOrphanedBlobs looks for orphaned blobs every interval until the storage is shut down
+func (fs *Decomposedfs) scanOrphanedBlobs(scanner orphanScanner, interval time.Duration) {
+	log := logger.New()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-fs.stopScan:
+			return
+		case <-ticker.C:
+			orphans, err := scanner.OrphanedBlobs(time.Duration(fs.o.OrphanedBlobsGracePeriod)*time.Second, fs.o.PurgeOrphanedBlobs)
+			if err != nil {
+				log.Error().Err(err).Msg("error scanning for orphaned blobs")
+				continue
+			}
+			if len(orphans) > 0 {
+				log.Warn().Strs("blobs", orphans).Bool("purged", fs.o.PurgeOrphanedBlobs).Msg("found orphaned blobs")
+			}
+		}
+	}
 }
 
 // Shutdown shuts down the storage
 func (fs *Decomposedfs) Shutdown(ctx context.Context) error {
+	if fs.stopScan != nil {
+		close(fs.stopScan)
+		fs.stopScan = nil
+	}
 	return nil
 }
 
@@ -372,7 +422,11 @@ func (fs *Decomposedfs) GetMD(ctx context.Context, ref *provider.Reference, mdKe
 		return nil, errtypes.PermissionDenied(node.ID)
 	}
 
-	return node.AsResourceInfo(ctx, rp, mdKeys)
+	if ri, err = node.AsResourceInfo(ctx, rp, mdKeys); err != nil {
+		return nil, err
+	}
+	fs.announceTreeSize(node, ri)
+	return ri, nil
 }
 
 // ListFolder returns a list of resources in the specified folder
@@ -406,12 +460,36 @@ func (fs *Decomposedfs) ListFolder(ctx context.Context, ref *provider.Reference,
 		// add this childs permissions
 		node.AddPermissions(np, n.PermissionSet(ctx))
 		if ri, err := children[i].AsResourceInfo(ctx, np, mdKeys); err == nil {
+			fs.announceTreeSize(children[i], ri)
 			finfos = append(finfos, ri)
 		}
 	}
 	return
 }
 
+// announceTreeSize adds the treesize of a container to the opaque of its resource info. The entry is left empty when
+// the treesize has not been propagated yet, so clients can distinguish an unknown treesize from an empty container.
+// Without treesize accounting there is no treesize to announce and containers keep reporting a size of 0.
+func (fs *Decomposedfs) announceTreeSize(n *node.Node, ri *provider.ResourceInfo) {
+	if !fs.o.TreeSizeAccounting || ri.Type != provider.ResourceType_RESOURCE_TYPE_CONTAINER {
+		return
+	}
+	treeSize := strconv.FormatUint(ri.Size, 10)
+	if _, err := n.GetTreeSize(); xattrs.IsNoData(err) {
+		treeSize = ""
+	}
+	if ri.Opaque == nil {
+		ri.Opaque = &types.Opaque{}
+	}
+	if ri.Opaque.Map == nil {
+		ri.Opaque.Map = map[string]*types.OpaqueEntry{}
+	}
+	ri.Opaque.Map[node.TreeSizeKey] = &types.OpaqueEntry{
+		Decoder: "plain",
+		Value:   []byte(treeSize),
+	}
+}
+
 // Delete deletes the specified resource
 func (fs *Decomposedfs) Delete(ctx context.Context, ref *provider.Reference) (err error) {
 	var node *node.Node
@@ -471,7 +549,8 @@ func (fs *Decomposedfs) copyMD(s string, t string) (err error) {
 		return err
 	}
 	for i := range attrs {
-		if strings.HasPrefix(attrs[i], xattrs.OcisPrefix) {
+		// the label belongs to the revision, not to its content
+		if strings.HasPrefix(attrs[i], xattrs.OcisPrefix) && attrs[i] != xattrs.RevisionLabelAttr {
 			var d []byte
 			if d, err = xattr.Get(s, attrs[i]); err != nil {
 				return err
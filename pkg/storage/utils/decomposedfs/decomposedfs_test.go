@@ -19,12 +19,22 @@
 package decomposedfs_test
 
 import (
+	"bytes"
+	"context"
+	"os"
+	"path"
+	"time"
+
 	"github.com/stretchr/testify/mock"
 
 	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/cs3org/reva/pkg/storage/fs/ocis/blobstore"
 	"github.com/cs3org/reva/pkg/storage/utils/decomposedfs"
+	"github.com/cs3org/reva/pkg/storage/utils/decomposedfs/node"
 	helpers "github.com/cs3org/reva/pkg/storage/utils/decomposedfs/testhelpers"
 	treemocks "github.com/cs3org/reva/pkg/storage/utils/decomposedfs/tree/mocks"
+	"github.com/cs3org/reva/pkg/storage/utils/decomposedfs/xattrs"
+	"github.com/pkg/xattr"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -65,6 +75,27 @@ var _ = Describe("Decomposed", func() {
 			}, bs)
 			Expect(err).ToNot(HaveOccurred())
 		})
+
+		It("purges orphaned blobs when scanning is enabled", func() {
+			bs, err := blobstore.New(path.Join(env.Root, "blobs"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(bs.Upload("orphan", bytes.NewReader([]byte("data")))).To(Succeed())
+			old := time.Now().Add(-2 * time.Hour)
+			Expect(os.Chtimes(path.Join(env.Root, "blobs", "orphan"), old, old)).To(Succeed())
+
+			fs, err := decomposedfs.NewDefault(map[string]interface{}{
+				"root":                         env.Root,
+				"orphaned_blobs_scan_interval": 1,
+				"purge_orphaned_blobs":         true,
+			}, bs)
+			Expect(err).ToNot(HaveOccurred())
+			defer fs.Shutdown(context.Background())
+
+			Eventually(func() bool {
+				_, err := os.Stat(path.Join(env.Root, "blobs", "orphan"))
+				return os.IsNotExist(err)
+			}, 3*time.Second, 100*time.Millisecond).Should(BeTrue())
+		})
 	})
 
 	Describe("Delete", func() {
@@ -91,4 +122,45 @@ var _ = Describe("Decomposed", func() {
 			})
 		})
 	})
+
+	Describe("the treesize of a container", func() {
+		JustBeforeEach(func() {
+			env.Permissions.On("AssemblePermissions", mock.Anything, mock.Anything).Return(&provider.ResourcePermissions{Stat: true}, nil)
+		})
+
+		It("is announced", func() {
+			n, err := env.Lookup.NodeFromPath(env.Ctx, "dir1")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n.SetTreeSize(1234)).To(Succeed())
+
+			ri, err := env.Fs.GetMD(env.Ctx, ref, []string{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ri.Size).To(Equal(uint64(1234)))
+			Expect(string(ri.Opaque.Map[node.TreeSizeKey].Value)).To(Equal("1234"))
+		})
+
+		It("is empty when it has not been propagated", func() {
+			n, err := env.Lookup.NodeFromPath(env.Ctx, "dir1")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(xattr.Remove(n.InternalPath(), xattrs.TreesizeAttr)).To(Succeed())
+
+			ri, err := env.Fs.GetMD(env.Ctx, ref, []string{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ri.Size).To(Equal(uint64(0)))
+			Expect(ri.Opaque.Map).To(HaveKey(node.TreeSizeKey))
+			Expect(ri.Opaque.Map[node.TreeSizeKey].Value).To(BeEmpty())
+		})
+
+		It("is not announced without treesize accounting", func() {
+			env.Lookup.Options.TreeSizeAccounting = false
+			n, err := env.Lookup.NodeFromPath(env.Ctx, "dir1")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(xattr.Remove(n.InternalPath(), xattrs.TreesizeAttr)).To(Succeed())
+
+			ri, err := env.Fs.GetMD(env.Ctx, ref, []string{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ri.Size).To(Equal(uint64(0)))
+			Expect(ri.Opaque.GetMap()).ToNot(HaveKey(node.TreeSizeKey))
+		})
+	})
 })
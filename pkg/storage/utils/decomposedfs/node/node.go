@@ -56,6 +56,7 @@ const (
 	ChecksumsKey  = "http://owncloud.org/ns/checksums"
 	UserShareType = "0"
 	QuotaKey      = "quota"
+	TreeSizeKey   = "treesize"
 
 	QuotaUncalculated = "-1"
 	QuotaUnknown      = "-2"
@@ -149,7 +150,7 @@ func ReadNode(ctx context.Context, lu PathLookup, id string) (n *Node, err error
 	switch {
 	case err == nil:
 		n.ParentID = string(attrBytes)
-	case isNoData(err):
+	case xattrs.IsNoData(err):
 		return nil, errtypes.InternalError(err.Error())
 	case isNotFound(err):
 		return n, nil // swallow not found, the node defaults to exists = false
@@ -600,7 +601,7 @@ func readChecksumIntoResourceChecksum(ctx context.Context, nodePath, algo string
 			Type: storageprovider.PKG2GRPCXS(algo),
 			Sum:  hex.EncodeToString(v),
 		}
-	case isNoData(err):
+	case xattrs.IsNoData(err):
 		appctx.GetLogger(ctx).Debug().Err(err).Str("nodepath", nodePath).Str("algorithm", algo).Msg("checksum not set")
 	case isNotFound(err):
 		appctx.GetLogger(ctx).Error().Err(err).Str("nodepath", nodePath).Str("algorithm", algo).Msg("file not fount")
@@ -622,7 +623,7 @@ func readChecksumIntoOpaque(ctx context.Context, nodePath, algo string, ri *prov
 			Decoder: "plain",
 			Value:   []byte(hex.EncodeToString(v)),
 		}
-	case isNoData(err):
+	case xattrs.IsNoData(err):
 		appctx.GetLogger(ctx).Debug().Err(err).Str("nodepath", nodePath).Str("algorithm", algo).Msg("checksum not set")
 	case isNotFound(err):
 		appctx.GetLogger(ctx).Error().Err(err).Str("nodepath", nodePath).Str("algorithm", algo).Msg("file not fount")
@@ -654,7 +655,7 @@ func readQuotaIntoOpaque(ctx context.Context, nodePath string, ri *provider.Reso
 		} else {
 			appctx.GetLogger(ctx).Error().Err(err).Str("nodepath", nodePath).Str("quota", string(v)).Msg("malformed quota")
 		}
-	case isNoData(err):
+	case xattrs.IsNoData(err):
 		appctx.GetLogger(ctx).Debug().Err(err).Str("nodepath", nodePath).Msg("quota not set")
 	case isNotFound(err):
 		appctx.GetLogger(ctx).Error().Err(err).Str("nodepath", nodePath).Msg("file not found when reading quota")
@@ -781,7 +782,7 @@ func (n *Node) ReadUserPermissions(ctx context.Context, u *userpb.User) (ap *pro
 		switch {
 		case err == nil:
 			AddPermissions(ap, g.GetPermissions())
-		case isNoData(err):
+		case xattrs.IsNoData(err):
 			err = nil
 			appctx.GetLogger(ctx).Error().Interface("node", n).Str("grant", grantees[i]).Interface("grantees", grantees).Msg("grant vanished from node after listing")
 			// continue with next segment
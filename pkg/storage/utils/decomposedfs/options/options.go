@@ -51,6 +51,25 @@ type Options struct {
 
 	// set an owner for the root node
 	Owner string `mapstructure:"owner"`
+
+	// UploadDirectory holds uploads in progress. It can be placed on a fast local disk, separate from the node tree.
+	// Defaults to the uploads folder in the root.
+	UploadDirectory string `mapstructure:"upload_directory"`
+
+	// PropagationBatchWindow is the time in milliseconds to wait for further changes in the same folder
+	// before propagating them together. 0 propagates every change on its own.
+	PropagationBatchWindow int `mapstructure:"propagation_batch_window"`
+
+	// OrphanedBlobsScanInterval is the number of seconds between scans for blobs that are no longer referenced
+	// by a node, a revision or a trashed node. 0 disables the scan.
+	OrphanedBlobsScanInterval int `mapstructure:"orphaned_blobs_scan_interval"`
+
+	// OrphanedBlobsGracePeriod is the number of seconds a blob has to be unmodified before it is considered orphaned,
+	// so blobs of uploads that are about to finish are left alone. Defaults to one hour.
+	OrphanedBlobsGracePeriod int `mapstructure:"orphaned_blobs_grace_period"`
+
+	// PurgeOrphanedBlobs deletes the blobs found by the scan instead of only logging them
+	PurgeOrphanedBlobs bool `mapstructure:"purge_orphaned_blobs"`
 }
 
 // New returns a new Options instance for the given configuration
@@ -76,5 +95,14 @@ func New(m map[string]interface{}) (*Options, error) {
 	// c.DataDirectory should never end in / unless it is the root
 	o.Root = filepath.Clean(o.Root)
 
+	if o.UploadDirectory == "" {
+		o.UploadDirectory = filepath.Join(o.Root, "uploads")
+	}
+	o.UploadDirectory = filepath.Clean(o.UploadDirectory)
+
+	if o.OrphanedBlobsGracePeriod == 0 {
+		o.OrphanedBlobsGracePeriod = 3600
+	}
+
 	return o, nil
 }
@@ -23,14 +23,18 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
 	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	types "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
 	"github.com/cs3org/reva/pkg/appctx"
 	"github.com/cs3org/reva/pkg/errtypes"
 	"github.com/cs3org/reva/pkg/storage/utils/decomposedfs/node"
+	"github.com/cs3org/reva/pkg/storage/utils/decomposedfs/xattrs"
 	"github.com/pkg/errors"
+	"github.com/pkg/xattr"
 )
 
 // Revision entries are stored inside the node folder and start with the same uuid as the current version.
@@ -43,13 +47,72 @@ import (
 
 // ListRevisions lists the revisions of the given resource
 func (fs *Decomposedfs) ListRevisions(ctx context.Context, ref *provider.Reference) (revisions []*provider.FileVersion, err error) {
-	var n *node.Node
-	if n, err = fs.lu.NodeFromResource(ctx, ref); err != nil {
-		return
+	np, items, err := fs.revisionItems(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions = []*provider.FileVersion{}
+	for i := range items {
+		rev, err := revisionOf(ctx, np, items[i])
+		if err != nil {
+			return nil, err
+		}
+		if rev != nil {
+			revisions = append(revisions, rev)
+		}
+	}
+	return
+}
+
+// ListRevisionsPage lists up to limit revisions of the given resource, newest first. The returned token
+// is passed to list the next page, it is empty after the last page. A limit of 0 lists all remaining revisions.
+// Only the revisions of the requested page are read from disk.
+func (fs *Decomposedfs) ListRevisionsPage(ctx context.Context, ref *provider.Reference, limit int, token string) ([]*provider.FileVersion, string, error) {
+	if token != "" {
+		if _, err := revisionTime(token); err != nil {
+			return nil, "", errtypes.BadRequest("invalid revision token " + token)
+		}
+	}
+	np, items, err := fs.revisionItems(ctx, ref)
+	if err != nil {
+		return nil, "", err
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return newerRevision(filepath.Base(items[i]), filepath.Base(items[j]))
+	})
+
+	// the token is the key of the last revision of the previous page, which may have been restored since
+	start := 0
+	for token != "" && start < len(items) && !newerRevision(token, filepath.Base(items[start])) {
+		start++
+	}
+
+	revisions := []*provider.FileVersion{}
+	for i := start; i < len(items); i++ {
+		if limit > 0 && len(revisions) == limit {
+			return revisions, revisions[limit-1].Key, nil
+		}
+		rev, err := revisionOf(ctx, np, items[i])
+		if err != nil {
+			return nil, "", err
+		}
+		if rev != nil {
+			revisions = append(revisions, rev)
+		}
+	}
+	return revisions, "", nil
+}
+
+// revisionItems checks that the current user may list the revisions of the given resource and returns
+// the internal path of its node along with the paths of its revisions
+func (fs *Decomposedfs) revisionItems(ctx context.Context, ref *provider.Reference) (string, []string, error) {
+	n, err := fs.lu.NodeFromResource(ctx, ref)
+	if err != nil {
+		return "", nil, err
 	}
 	if !n.Exists {
-		err = errtypes.NotFound(filepath.Join(n.ParentID, n.Name))
-		return
+		return "", nil, errtypes.NotFound(filepath.Join(n.ParentID, n.Name))
 	}
 
 	ok, err := fs.p.HasPermission(ctx, n, func(rp *provider.ResourcePermissions) bool {
@@ -57,36 +120,146 @@ func (fs *Decomposedfs) ListRevisions(ctx context.Context, ref *provider.Referen
 	})
 	switch {
 	case err != nil:
-		return nil, errtypes.InternalError(err.Error())
+		return "", nil, errtypes.InternalError(err.Error())
 	case !ok:
-		return nil, errtypes.PermissionDenied(filepath.Join(n.ParentID, n.Name))
+		return "", nil, errtypes.PermissionDenied(filepath.Join(n.ParentID, n.Name))
 	}
 
-	revisions = []*provider.FileVersion{}
 	np := n.InternalPath()
-	if items, err := filepath.Glob(np + ".REV.*"); err == nil {
-		for i := range items {
-			if fi, err := os.Stat(items[i]); err == nil {
-				mtime := fi.ModTime()
-				rev := &provider.FileVersion{
-					Key:   filepath.Base(items[i]),
-					Mtime: uint64(mtime.Unix()),
-				}
-				blobSize, err := node.ReadBlobSizeAttr(items[i])
-				if err != nil {
-					return nil, errors.Wrapf(err, "error reading blobsize xattr")
-				}
-				rev.Size = uint64(blobSize)
-				etag, err := node.CalculateEtag(np, mtime)
-				if err != nil {
-					return nil, errors.Wrapf(err, "error calculating etag")
-				}
-				rev.Etag = etag
-				revisions = append(revisions, rev)
-			}
+	// the pattern is well formed, so globbing cannot fail
+	items, _ := filepath.Glob(np + ".REV.*")
+	return np, items, nil
+}
+
+// revisionOf reads the revision stored at item of the node at np. It returns nil if the item is not a valid revision.
+func revisionOf(ctx context.Context, np, item string) (*provider.FileVersion, error) {
+	log := appctx.GetLogger(ctx)
+	// do not follow symlinks, a revision pointing into a symlink loop would make stat fail confusingly
+	fi, err := os.Lstat(item)
+	if err != nil {
+		log.Error().Err(err).Str("revision", item).Msg("Decomposedfs: could not stat revision, skipping")
+		return nil, nil
+	}
+	if !fi.Mode().IsRegular() {
+		log.Warn().Str("revision", item).Str("mode", fi.Mode().String()).Msg("Decomposedfs: revision is not a regular file, skipping")
+		return nil, nil
+	}
+	mtime := fi.ModTime()
+	rev := &provider.FileVersion{
+		Key:   filepath.Base(item),
+		Mtime: uint64(mtime.Unix()),
+	}
+	blobSize, err := node.ReadBlobSizeAttr(item)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading blobsize xattr")
+	}
+	rev.Size = uint64(blobSize)
+	etag, err := node.CalculateEtag(np, mtime)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error calculating etag")
+	}
+	rev.Etag = etag
+	if label, err := xattr.Get(item, xattrs.RevisionLabelAttr); err == nil {
+		rev.Opaque = &types.Opaque{
+			Map: map[string]*types.OpaqueEntry{
+				"label": {Decoder: "plain", Value: label},
+			},
 		}
 	}
-	return
+	return rev, nil
+}
+
+// revisionTime returns the time encoded in a revision key
+func revisionTime(key string) (time.Time, error) {
+	kp := strings.SplitN(key, ".REV.", 2)
+	if len(kp) != 2 {
+		return time.Time{}, errors.New("malformed revision key " + key)
+	}
+	return time.Parse(time.RFC3339Nano, kp[1])
+}
+
+// newerRevision checks if the revision with key a was created after the one with key b
+func newerRevision(a, b string) bool {
+	ta, _ := revisionTime(a)
+	tb, _ := revisionTime(b)
+	if !ta.Equal(tb) {
+		return ta.After(tb)
+	}
+	return a > b
+}
+
+// SetRevisionLabel labels the specified revision of the resource, an empty label removes it
+func (fs *Decomposedfs) SetRevisionLabel(ctx context.Context, ref *provider.Reference, revisionKey, label string) error {
+	revisionPath, err := fs.revisionPath(ctx, ref, revisionKey, func(rp *provider.ResourcePermissions) bool {
+		return rp.ListFileVersions && rp.RestoreFileVersion
+	})
+	if err != nil {
+		return err
+	}
+	if label == "" {
+		if err := xattr.Remove(revisionPath, xattrs.RevisionLabelAttr); err != nil && !xattrs.IsNoData(err) {
+			return errors.Wrap(err, "Decomposedfs: error removing revision label")
+		}
+		return nil
+	}
+	return xattr.Set(revisionPath, xattrs.RevisionLabelAttr, []byte(label))
+}
+
+// GetRevisionLabel returns the label of the specified revision of the resource, or an empty string if it has none
+func (fs *Decomposedfs) GetRevisionLabel(ctx context.Context, ref *provider.Reference, revisionKey string) (string, error) {
+	revisionPath, err := fs.revisionPath(ctx, ref, revisionKey, func(rp *provider.ResourcePermissions) bool {
+		return rp.ListFileVersions
+	})
+	if err != nil {
+		return "", err
+	}
+	label, err := xattr.Get(revisionPath, xattrs.RevisionLabelAttr)
+	switch {
+	case err == nil:
+		return string(label), nil
+	case xattrs.IsNoData(err):
+		return "", nil
+	default:
+		return "", errors.Wrap(err, "Decomposedfs: error reading revision label")
+	}
+}
+
+// revisionPath returns the internal path of an existing revision of the resource after checking the permissions on its node
+func (fs *Decomposedfs) revisionPath(ctx context.Context, ref *provider.Reference, revisionKey string, f func(*provider.ResourcePermissions) bool) (string, error) {
+	n, err := fs.lu.NodeFromResource(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	if !n.Exists {
+		return "", errtypes.NotFound(filepath.Join(n.ParentID, n.Name))
+	}
+
+	// the revision has to belong to the resource
+	kp := strings.SplitN(revisionKey, ".REV.", 2)
+	if len(kp) != 2 || kp[0] != n.ID {
+		return "", errtypes.NotFound(revisionKey)
+	}
+
+	ok, err := fs.p.HasPermission(ctx, n, f)
+	switch {
+	case err != nil:
+		return "", errtypes.InternalError(err.Error())
+	case !ok:
+		return "", errtypes.PermissionDenied(filepath.Join(n.ParentID, n.Name))
+	}
+
+	revisionPath := fs.lu.InternalPath(revisionKey)
+	fi, err := os.Lstat(revisionPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", errtypes.NotFound(revisionKey)
+		}
+		return "", errors.Wrap(err, "Decomposedfs: error reading revision "+revisionKey)
+	}
+	if !fi.Mode().IsRegular() {
+		return "", errtypes.NotFound(revisionKey)
+	}
+	return revisionPath, nil
 }
 
 // DownloadRevision returns a reader for the specified revision
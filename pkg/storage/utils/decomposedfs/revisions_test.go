@@ -0,0 +1,212 @@
+// Copyright 2018-2021 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package decomposedfs_test
+
+import (
+	"io/ioutil"
+	"os"
+	"time"
+
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/cs3org/reva/pkg/storage/utils/decomposedfs"
+	helpers "github.com/cs3org/reva/pkg/storage/utils/decomposedfs/testhelpers"
+	"github.com/cs3org/reva/pkg/storage/utils/decomposedfs/xattrs"
+	"github.com/pkg/xattr"
+	"github.com/stretchr/testify/mock"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Revisions", func() {
+	var (
+		env *helpers.TestEnv
+
+		ref *provider.Reference
+	)
+
+	BeforeEach(func() {
+		ref = &provider.Reference{
+			Spec: &provider.Reference_Path{
+				Path: "/dir1/file1",
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		env, err = helpers.NewTestEnv()
+		Expect(err).ToNot(HaveOccurred())
+		env.Permissions.On("HasPermission", mock.Anything, mock.Anything, mock.Anything).Return(true, nil)
+	})
+
+	AfterEach(func() {
+		if env != nil {
+			env.Cleanup()
+		}
+	})
+
+	Describe("ListRevisions", func() {
+		It("skips revisions that are not regular files without following them", func() {
+			n, err := env.Lookup.NodeFromPath(env.Ctx, "/dir1/file1")
+			Expect(err).ToNot(HaveOccurred())
+
+			revision := n.InternalPath() + ".REV.2021-06-01T00:00:00.000000000Z"
+			f, err := os.Create(revision)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(f.Close()).To(Succeed())
+			Expect(xattr.Set(revision, xattrs.BlobsizeAttr, []byte("10"))).To(Succeed())
+
+			// a symlink pointing to itself can never be resolved
+			loop := n.InternalPath() + ".REV.2021-06-02T00:00:00.000000000Z"
+			Expect(os.Symlink(loop, loop)).To(Succeed())
+
+			revisions, err := env.Fs.ListRevisions(env.Ctx, ref)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(revisions)).To(Equal(1))
+			Expect(revisions[0].Key).To(Equal(n.ID + ".REV.2021-06-01T00:00:00.000000000Z"))
+			Expect(revisions[0].Size).To(Equal(uint64(10)))
+		})
+	})
+
+	Describe("revision labels", func() {
+		var (
+			dfs *decomposedfs.Decomposedfs
+			key string
+		)
+
+		JustBeforeEach(func() {
+			dfs = env.Fs.(*decomposedfs.Decomposedfs)
+			n, err := env.Lookup.NodeFromPath(env.Ctx, "/dir1/file1")
+			Expect(err).ToNot(HaveOccurred())
+
+			key = n.ID + ".REV.2021-06-01T00:00:00Z"
+			revision := n.InternalPath() + ".REV.2021-06-01T00:00:00Z"
+			Expect(ioutil.WriteFile(revision, []byte("rev"), 0600)).To(Succeed())
+			Expect(xattr.Set(revision, xattrs.BlobsizeAttr, []byte("3"))).To(Succeed())
+		})
+
+		It("are listed with the revision", func() {
+			Expect(dfs.SetRevisionLabel(env.Ctx, ref, key, "before migration")).To(Succeed())
+
+			label, err := dfs.GetRevisionLabel(env.Ctx, ref, key)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(label).To(Equal("before migration"))
+
+			revisions, err := env.Fs.ListRevisions(env.Ctx, ref)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(revisions)).To(Equal(1))
+			Expect(string(revisions[0].Opaque.Map["label"].Value)).To(Equal("before migration"))
+		})
+
+		It("can be removed", func() {
+			Expect(dfs.SetRevisionLabel(env.Ctx, ref, key, "before migration")).To(Succeed())
+			Expect(dfs.SetRevisionLabel(env.Ctx, ref, key, "")).To(Succeed())
+
+			label, err := dfs.GetRevisionLabel(env.Ctx, ref, key)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(label).To(BeEmpty())
+
+			revisions, err := env.Fs.ListRevisions(env.Ctx, ref)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(revisions[0].Opaque).To(BeNil())
+		})
+
+		It("cannot be set on unknown revisions", func() {
+			err := dfs.SetRevisionLabel(env.Ctx, ref, key+"0", "label")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("cannot be accessed through another resource", func() {
+			other := &provider.Reference{Spec: &provider.Reference_Path{Path: "/dir1"}}
+			err := dfs.SetRevisionLabel(env.Ctx, other, key, "label")
+			Expect(err).To(HaveOccurred())
+
+			_, err = dfs.GetRevisionLabel(env.Ctx, other, key)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("ListRevisionsPage", func() {
+		var (
+			dfs  *decomposedfs.Decomposedfs
+			keys []string
+		)
+
+		JustBeforeEach(func() {
+			dfs = env.Fs.(*decomposedfs.Decomposedfs)
+			n, err := env.Lookup.NodeFromPath(env.Ctx, "/dir1/file1")
+			Expect(err).ToNot(HaveOccurred())
+
+			keys = []string{}
+			for i := 1; i <= 7; i++ {
+				key := n.ID + ".REV." + time.Date(2021, 6, i, 0, 0, 0, 0, time.UTC).Format(time.RFC3339Nano)
+				revision := n.InternalPath() + key[len(n.ID):]
+				Expect(ioutil.WriteFile(revision, []byte("rev"), 0600)).To(Succeed())
+				Expect(xattr.Set(revision, xattrs.BlobsizeAttr, []byte("3"))).To(Succeed())
+				// newest first
+				keys = append([]string{key}, keys...)
+			}
+		})
+
+		It("pages through the revisions newest first", func() {
+			listed := []string{}
+			token := ""
+			for pages := 1; ; pages++ {
+				revisions, next, err := dfs.ListRevisionsPage(env.Ctx, ref, 3, token)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(len(revisions)).To(BeNumerically("<=", 3))
+				for _, r := range revisions {
+					listed = append(listed, r.Key)
+				}
+				if next == "" {
+					Expect(pages).To(Equal(3))
+					break
+				}
+				token = next
+			}
+			Expect(listed).To(Equal(keys))
+		})
+
+		It("only reads the revisions of the requested page", func() {
+			n, err := env.Lookup.NodeFromPath(env.Ctx, "/dir1/file1")
+			Expect(err).ToNot(HaveOccurred())
+			// the oldest revision cannot be read anymore
+			oldest := keys[len(keys)-1]
+			Expect(xattr.Remove(n.InternalPath()+oldest[len(n.ID):], xattrs.BlobsizeAttr)).To(Succeed())
+
+			revisions, next, err := dfs.ListRevisionsPage(env.Ctx, ref, 3, "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(revisions)).To(Equal(3))
+			Expect(next).To(Equal(keys[2]))
+		})
+
+		It("lists all revisions without a limit", func() {
+			revisions, next, err := dfs.ListRevisionsPage(env.Ctx, ref, 0, "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(next).To(BeEmpty())
+			Expect(len(revisions)).To(Equal(7))
+		})
+
+		It("rejects invalid tokens", func() {
+			_, _, err := dfs.ListRevisionsPage(env.Ctx, ref, 3, "invalid")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
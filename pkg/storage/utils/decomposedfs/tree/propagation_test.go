@@ -0,0 +1,72 @@
+// Copyright 2018-2021 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package tree
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cs3org/reva/pkg/storage/utils/decomposedfs/node"
+)
+
+func TestBatchPropagation(t *testing.T) {
+	tree := New("", true, true, nil, nil)
+	tree.SetPropagationBatchWindow(50 * time.Millisecond)
+
+	var walks int32
+	walk := func(ctx context.Context, n *node.Node) error {
+		atomic.AddInt32(&walks, 1)
+		return nil
+	}
+
+	// several uploads finishing in the same folder at the same time
+	uploads := 10
+	var wg sync.WaitGroup
+	for i := 0; i < uploads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := tree.batchPropagation(context.Background(), &node.Node{ParentID: "folder"}, walk); err != nil {
+				t.Errorf("propagation failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if w := atomic.LoadInt32(&walks); w == 0 || int(w) >= uploads {
+		t.Errorf("%d uploads caused %d propagations, expected them to be batched", uploads, w)
+	}
+
+	// changes in other folders are propagated separately
+	atomic.StoreInt32(&walks, 0)
+	for _, parent := range []string{"a", "b"} {
+		wg.Add(1)
+		go func(parent string) {
+			defer wg.Done()
+			_ = tree.batchPropagation(context.Background(), &node.Node{ParentID: parent}, walk)
+		}(parent)
+	}
+	wg.Wait()
+	if w := atomic.LoadInt32(&walks); w != 2 {
+		t.Errorf("changes in two folders caused %d propagations instead of expected 2", w)
+	}
+}
@@ -24,8 +24,10 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
@@ -49,6 +51,12 @@ type Blobstore interface {
 	Delete(key string) error
 }
 
+// BlobLister is implemented by blobstores that can enumerate the blobs they contain
+type BlobLister interface {
+	// List returns the keys of all blobs with the time they were last modified
+	List() (map[string]time.Time, error)
+}
+
 // PathLookup defines the interface for the lookup component
 type PathLookup interface {
 	NodeFromPath(ctx context.Context, fn string) (*node.Node, error)
@@ -69,6 +77,16 @@ type Tree struct {
 	root               string
 	treeSizeAccounting bool
 	treeTimeAccounting bool
+
+	propagationBatchWindow time.Duration
+	batchesMu              sync.Mutex
+	batches                map[string]*propagationBatch
+}
+
+// propagationBatch collects the propagations of changes in the same folder
+type propagationBatch struct {
+	done chan struct{}
+	err  error
 }
 
 // PermissionCheckFunc defined a function used to check resource permissions
@@ -82,9 +100,16 @@ func New(root string, tta bool, tsa bool, lu PathLookup, bs Blobstore) *Tree {
 		root:               root,
 		treeTimeAccounting: tta,
 		treeSizeAccounting: tsa,
+		batches:            map[string]*propagationBatch{},
 	}
 }
 
+// SetPropagationBatchWindow sets the time to wait for further changes in the same folder before propagating.
+// Changes in the same folder that arrive within the window are propagated with a single walk to the root.
+func (t *Tree) SetPropagationBatchWindow(d time.Duration) {
+	t.propagationBatchWindow = d
+}
+
 // Setup prepares the tree structure
 func (t *Tree) Setup(owner string) error {
 	// create data paths for internal layout
@@ -271,6 +296,57 @@ func (t *Tree) ListFolder(ctx context.Context, n *node.Node) ([]*node.Node, erro
 	return nodes, nil
 }
 
+// OrphanedBlobs returns the keys of all blobs that are neither referenced by a node, a revision nor a trashed node.
+// Blobs that have been modified within the grace period are ignored, because a concurrent upload might be about to
+// reference them. When purge is true the orphaned blobs are deleted from the blobstore.
+// The blobstore has to implement the BlobLister interface.
+func (t *Tree) OrphanedBlobs(gracePeriod time.Duration, purge bool) ([]string, error) {
+	bl, ok := t.blobstore.(BlobLister)
+	if !ok {
+		return nil, errtypes.NotSupported("tree: blobstore can not list blobs")
+	}
+	// list the blobs before the nodes, so blobs of uploads finishing in between are covered by the grace period
+	blobs, err := bl.List()
+	if err != nil {
+		return nil, errors.Wrap(err, "tree: error listing blobs")
+	}
+
+	// revisions and trashed nodes are kept next to the nodes
+	dir := filepath.Join(t.root, "nodes")
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "tree: error listing "+dir)
+	}
+	defer f.Close()
+	names, err := f.Readdirnames(0)
+	if err != nil {
+		return nil, errors.Wrap(err, "tree: error listing "+dir)
+	}
+	referenced := make(map[string]struct{}, len(names))
+	for i := range names {
+		// containers and empty files have no blob
+		if id, err := xattr.Get(filepath.Join(dir, names[i]), xattrs.BlobIDAttr); err == nil && len(id) > 0 {
+			referenced[string(id)] = struct{}{}
+		}
+	}
+
+	orphans := []string{}
+	cutoff := time.Now().Add(-gracePeriod)
+	for key, mtime := range blobs {
+		if _, ok := referenced[key]; ok || mtime.After(cutoff) {
+			continue
+		}
+		if purge {
+			if err := t.DeleteBlob(key); err != nil {
+				return nil, errors.Wrap(err, "tree: error deleting orphaned blob "+key)
+			}
+		}
+		orphans = append(orphans, key)
+	}
+	sort.Strings(orphans)
+	return orphans, nil
+}
+
 // Delete deletes a node in the tree by moving it to the trash
 func (t *Tree) Delete(ctx context.Context, n *node.Node) (err error) {
 
@@ -426,12 +502,44 @@ func (t *Tree) PurgeRecycleItemFunc(ctx context.Context, key string) (*node.Node
 
 // Propagate propagates changes to the root of the tree
 func (t *Tree) Propagate(ctx context.Context, n *node.Node) (err error) {
-	sublog := appctx.GetLogger(ctx).With().Interface("node", n).Logger()
 	if !t.treeTimeAccounting && !t.treeSizeAccounting {
 		// no propagation enabled
-		sublog.Debug().Msg("propagation disabled")
+		appctx.GetLogger(ctx).Debug().Interface("node", n).Msg("propagation disabled")
 		return
 	}
+	if t.propagationBatchWindow <= 0 {
+		return t.propagate(ctx, n)
+	}
+	return t.batchPropagation(ctx, n, t.propagate)
+}
+
+// batchPropagation lets changes in the same folder share a single propagation. The first change starts a batch
+// and waits for the batch window before walking the tree, later changes wait for that walk to finish.
+// Changes arriving after the walk started begin a new batch, so every change is propagated before returning.
+func (t *Tree) batchPropagation(ctx context.Context, n *node.Node, walk func(context.Context, *node.Node) error) error {
+	t.batchesMu.Lock()
+	if b, ok := t.batches[n.ParentID]; ok {
+		t.batchesMu.Unlock()
+		<-b.done
+		return b.err
+	}
+	b := &propagationBatch{done: make(chan struct{})}
+	t.batches[n.ParentID] = b
+	t.batchesMu.Unlock()
+
+	time.Sleep(t.propagationBatchWindow)
+
+	t.batchesMu.Lock()
+	delete(t.batches, n.ParentID)
+	t.batchesMu.Unlock()
+
+	b.err = walk(ctx, n)
+	close(b.done)
+	return b.err
+}
+
+func (t *Tree) propagate(ctx context.Context, n *node.Node) (err error) {
+	sublog := appctx.GetLogger(ctx).With().Interface("node", n).Logger()
 
 	// is propagation enabled for the parent node?
 
@@ -19,9 +19,12 @@
 package tree_test
 
 import (
+	"bytes"
 	"os"
 	"path"
+	"time"
 
+	"github.com/cs3org/reva/pkg/storage/fs/ocis/blobstore"
 	"github.com/cs3org/reva/pkg/storage/utils/decomposedfs/node"
 	helpers "github.com/cs3org/reva/pkg/storage/utils/decomposedfs/testhelpers"
 	"github.com/cs3org/reva/pkg/storage/utils/decomposedfs/tree"
@@ -319,4 +322,51 @@ var _ = Describe("Tree", func() {
 			})
 		})
 	})
+
+	Describe("OrphanedBlobs", func() {
+		var (
+			bs *blobstore.Blobstore
+			t  *tree.Tree
+		)
+
+		JustBeforeEach(func() {
+			var err error
+			bs, err = blobstore.New(path.Join(env.Root, "blobs"))
+			Expect(err).ToNot(HaveOccurred())
+			t = tree.New(env.Root, true, true, env.Lookup, bs)
+
+			old := time.Now().Add(-2 * time.Hour)
+			for _, key := range []string{"file1-blobid", "orphan", "fresh"} {
+				Expect(bs.Upload(key, bytes.NewReader([]byte("data")))).To(Succeed())
+				if key != "fresh" {
+					Expect(os.Chtimes(path.Join(env.Root, "blobs", key), old, old)).To(Succeed())
+				}
+			}
+		})
+
+		It("reports blobs without a referencing node outside the grace period", func() {
+			orphans, err := t.OrphanedBlobs(time.Hour, false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(orphans).To(Equal([]string{"orphan"}))
+
+			_, err = os.Stat(path.Join(env.Root, "blobs", "orphan"))
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("deletes the orphaned blobs when purging", func() {
+			orphans, err := t.OrphanedBlobs(time.Hour, true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(orphans).To(Equal([]string{"orphan"}))
+
+			_, err = os.Stat(path.Join(env.Root, "blobs", "orphan"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+			_, err = os.Stat(path.Join(env.Root, "blobs", "file1-blobid"))
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("fails for blobstores that can not list blobs", func() {
+			_, err := env.Tree.OrphanedBlobs(time.Hour, false)
+			Expect(err).To(HaveOccurred())
+		})
+	})
 })
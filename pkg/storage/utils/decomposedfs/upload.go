@@ -31,7 +31,9 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
@@ -41,9 +43,11 @@ import (
 	"github.com/cs3org/reva/pkg/logger"
 	"github.com/cs3org/reva/pkg/storage/utils/chunking"
 	"github.com/cs3org/reva/pkg/storage/utils/decomposedfs/node"
+	"github.com/cs3org/reva/pkg/storage/utils/decomposedfs/xattrs"
 	"github.com/cs3org/reva/pkg/user"
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
+	"github.com/pkg/xattr"
 	"github.com/rs/zerolog"
 	tusd "github.com/tus/tusd/pkg/handler"
 )
@@ -96,6 +100,19 @@ func (fs *Decomposedfs) Upload(ctx context.Context, ref *provider.Reference, r i
 		}
 		defer fd.Close()
 		defer os.RemoveAll(assembledFile)
+		fi, err := fd.Stat()
+		if err != nil {
+			return errors.Wrap(err, "Decomposedfs: error reading assembled file")
+		}
+		// the upload was initiated with the length of the last chunk
+		if totalLength := uploadInfo.info.MetaData["total_length"]; totalLength != "" && totalLength != strconv.FormatInt(fi.Size(), 10) {
+			if err = uploadInfo.Terminate(ctx); err != nil {
+				return errors.Wrap(err, "Decomposedfs: error removing auxiliary files")
+			}
+			return errtypes.BadRequest(fmt.Sprintf("assembled file has %d bytes instead of the announced %s", fi.Size(), totalLength))
+		}
+		uploadInfo.info.Size = fi.Size()
+		uploadInfo.info.SizeIsDeferred = false
 		r = fd
 	}
 
@@ -154,6 +171,19 @@ func (fs *Decomposedfs) InitiateUpload(ctx context.Context, ref *provider.Refere
 				return nil, errtypes.BadRequest("unsupported checksum algorithm: " + parts[0])
 			}
 		}
+		if metadata["total_length"] != "" {
+			if _, err := strconv.ParseInt(metadata["total_length"], 10, 64); err != nil {
+				return nil, errtypes.BadRequest("invalid total length: " + metadata["total_length"])
+			}
+			info.MetaData["total_length"] = metadata["total_length"]
+		}
+		if metadata["arbitrary_metadata"] != "" {
+			md := map[string]string{}
+			if err := json.Unmarshal([]byte(metadata["arbitrary_metadata"]), &md); err != nil {
+				return nil, errtypes.BadRequest("invalid arbitrary metadata: " + err.Error())
+			}
+			info.MetaData["arbitrary_metadata"] = metadata["arbitrary_metadata"]
+		}
 	}
 
 	log.Debug().Interface("info", info).Interface("node", n).Interface("metadata", metadata).Msg("Decomposedfs: resolved filename")
@@ -280,7 +310,7 @@ func (fs *Decomposedfs) NewUpload(ctx context.Context, info tusd.FileInfo) (uplo
 	u := &fileUpload{
 		info:     info,
 		binPath:  binPath,
-		infoPath: filepath.Join(fs.o.Root, "uploads", info.ID+".info"),
+		infoPath: filepath.Join(fs.o.UploadDirectory, info.ID+".info"),
 		fs:       fs,
 		ctx:      ctx,
 	}
@@ -305,12 +335,12 @@ func (fs *Decomposedfs) NewUpload(ctx context.Context, info tusd.FileInfo) (uplo
 }
 
 func (fs *Decomposedfs) getUploadPath(ctx context.Context, uploadID string) (string, error) {
-	return filepath.Join(fs.o.Root, "uploads", uploadID), nil
+	return filepath.Join(fs.o.UploadDirectory, uploadID), nil
 }
 
 // GetUpload returns the Upload for the given upload id
 func (fs *Decomposedfs) GetUpload(ctx context.Context, id string) (tusd.Upload, error) {
-	infoPath := filepath.Join(fs.o.Root, "uploads", id+".info")
+	infoPath := filepath.Join(fs.o.UploadDirectory, id+".info")
 
 	info := tusd.FileInfo{}
 	data, err := ioutil.ReadFile(infoPath)
@@ -357,6 +387,56 @@ func (fs *Decomposedfs) GetUpload(ctx context.Context, id string) (tusd.Upload,
 	}, nil
 }
 
+// ListUploads returns the uploads in progress that target folders the current user may manage,
+// ie. share with others. Uploads of other users into those folders are included.
+// It is not part of the storage.FS interface, because the CS3 API has no call for listing uploads.
+func (fs *Decomposedfs) ListUploads(ctx context.Context) ([]tusd.FileInfo, error) {
+	if _, ok := user.ContextGetUser(ctx); !ok {
+		return nil, errtypes.UserRequired("Decomposedfs: no user in context")
+	}
+
+	infoFiles, err := filepath.Glob(filepath.Join(fs.o.UploadDirectory, "*.info"))
+	if err != nil {
+		return nil, errors.Wrap(err, "Decomposedfs: error listing uploads")
+	}
+
+	log := appctx.GetLogger(ctx)
+	uploads := []tusd.FileInfo{}
+	for _, infoFile := range infoFiles {
+		info := tusd.FileInfo{}
+		data, err := ioutil.ReadFile(infoFile)
+		if err != nil {
+			// the upload might have been finished in the meantime
+			continue
+		}
+		if err := json.Unmarshal(data, &info); err != nil {
+			log.Error().Err(err).Str("info", infoFile).Msg("Decomposedfs: could not read upload info")
+			continue
+		}
+		parent, err := node.ReadNode(ctx, fs.lu, info.Storage["NodeParentId"])
+		if err != nil || !parent.Exists {
+			continue
+		}
+		ok, err := fs.p.HasPermission(ctx, parent, func(rp *provider.ResourcePermissions) bool {
+			return rp.AddGrant
+		})
+		if err != nil {
+			log.Error().Err(err).Str("upload", info.ID).Msg("Decomposedfs: could not check permissions of upload")
+			continue
+		}
+		if !ok {
+			continue
+		}
+		stat, err := os.Stat(info.Storage["BinPath"])
+		if err != nil {
+			continue
+		}
+		info.Offset = stat.Size()
+		uploads = append(uploads, info)
+	}
+	return uploads, nil
+}
+
 type fileUpload struct {
 	// info stores the current information about the upload
 	info tusd.FileInfo
@@ -531,14 +611,13 @@ func (upload *fileUpload) FinishUpload(ctx context.Context) (err error) {
 	}
 
 	// now truncate the upload (the payload stays in the blobstore) and move it to the target path
-	// TODO put uploads on the same underlying storage as the destination dir?
 	// TODO trigger a workflow as the final rename might eg involve antivirus scanning
 	if err = os.Truncate(upload.binPath, 0); err != nil {
 		sublog.Err(err).
 			Msg("Decomposedfs: could not truncate")
 		return
 	}
-	if err = os.Rename(upload.binPath, targetPath); err != nil {
+	if err = moveEmptyFile(upload.binPath, targetPath); err != nil {
 		sublog.Err(err).
 			Msg("Decomposedfs: could not rename")
 		return
@@ -558,6 +637,19 @@ func (upload *fileUpload) FinishUpload(ctx context.Context) (err error) {
 		return errors.Wrap(err, "Decomposedfs: could not write metadata")
 	}
 
+	// persist the arbitrary metadata sent with the upload
+	if upload.info.MetaData["arbitrary_metadata"] != "" {
+		md := map[string]string{}
+		if err = json.Unmarshal([]byte(upload.info.MetaData["arbitrary_metadata"]), &md); err != nil {
+			return errors.Wrap(err, "Decomposedfs: could not unmarshal arbitrary metadata")
+		}
+		for k, v := range md {
+			if err = xattr.Set(targetPath, xattrs.MetadataPrefix+k, []byte(v)); err != nil {
+				return errors.Wrap(err, "Decomposedfs: could not set arbitrary metadata "+k)
+			}
+		}
+	}
+
 	// link child name to parent if it is new
 	childNameLink := filepath.Join(upload.fs.lu.InternalPath(n.ParentID), n.Name)
 	var link string
@@ -579,6 +671,14 @@ func (upload *fileUpload) FinishUpload(ctx context.Context) (err error) {
 		}
 	}
 
+	// keep the mtime sent by the client, eg. with the X-OC-Mtime header
+	if upload.info.MetaData["mtime"] != "" {
+		if err = n.SetMtime(ctx, upload.info.MetaData["mtime"]); err != nil {
+			sublog.Err(err).Interface("info", upload.info).Msg("Decomposedfs: could not set mtime metadata")
+			return err
+		}
+	}
+
 	// only delete the upload if it was successfully written to the storage
 	if err = os.Remove(upload.infoPath); err != nil {
 		if !os.IsNotExist(err) {
@@ -586,20 +686,28 @@ func (upload *fileUpload) FinishUpload(ctx context.Context) (err error) {
 			return
 		}
 	}
-	// use set arbitrary metadata?
-	/*if upload.info.MetaData["mtime"] != "" {
-		err := upload.fs.SetMtime(ctx, np, upload.info.MetaData["mtime"])
-		if err != nil {
-			log.Err(err).Interface("info", upload.info).Msg("Decomposedfs: could not set mtime metadata")
-			return err
-		}
-	}*/
-
 	n.Exists = true
 
 	return upload.fs.tp.Propagate(upload.ctx, n)
 }
 
+// moveEmptyFile moves a truncated upload into the node tree. The upload directory might be located
+// on a different device than the node tree, in which case the file is recreated at the target.
+func moveEmptyFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if le, ok := err.(*os.LinkError); !ok || le.Err != syscall.EXDEV {
+		return err
+	}
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, defaultFilePerm)
+	if err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
 func (upload *fileUpload) checkHash(expected string, h hash.Hash) error {
 	if expected != hex.EncodeToString(h.Sum(nil)) {
 		upload.discardChunk()
@@ -24,6 +24,7 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"strconv"
 
 	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
 	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
@@ -33,6 +34,7 @@ import (
 	"github.com/cs3org/reva/pkg/storage/utils/decomposedfs"
 	"github.com/cs3org/reva/pkg/storage/utils/decomposedfs/mocks"
 	"github.com/cs3org/reva/pkg/storage/utils/decomposedfs/options"
+	testhelpers "github.com/cs3org/reva/pkg/storage/utils/decomposedfs/testhelpers"
 	"github.com/cs3org/reva/pkg/storage/utils/decomposedfs/tree"
 	treemocks "github.com/cs3org/reva/pkg/storage/utils/decomposedfs/tree/mocks"
 	ruser "github.com/cs3org/reva/pkg/user"
@@ -125,11 +127,83 @@ var _ = Describe("File uploads", func() {
 			})
 		})
 
+		Describe("ListUploads", func() {
+			It("lists uploads in progress in folders the user may manage", func() {
+				env, err := testhelpers.NewTestEnv()
+				Expect(err).ToNot(HaveOccurred())
+				defer env.Cleanup()
+				env.Permissions.On("HasPermission", mock.Anything, mock.Anything, mock.Anything).Return(true, nil)
+				env.Permissions.On("AssemblePermissions", mock.Anything, mock.Anything).Return(&provider.ResourcePermissions{GetQuota: true}, nil)
+				dfs := env.Fs.(*decomposedfs.Decomposedfs)
+
+				ids := map[string]bool{}
+				for _, name := range []string{"/foo", "/bar"} {
+					uploadIds, err := dfs.InitiateUpload(env.Ctx, &provider.Reference{
+						Spec: &provider.Reference_Path{Path: name},
+					}, 10, map[string]string{})
+					Expect(err).ToNot(HaveOccurred())
+					ids[uploadIds["tus"]] = true
+				}
+
+				upload, err := dfs.GetUpload(env.Ctx, keys(ids)[0])
+				Expect(err).ToNot(HaveOccurred())
+				_, err = upload.WriteChunk(env.Ctx, 0, bytes.NewReader([]byte("01234")))
+				Expect(err).ToNot(HaveOccurred())
+
+				uploads, err := dfs.ListUploads(env.Ctx)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(len(uploads)).To(Equal(2))
+				var offset int64
+				for _, info := range uploads {
+					Expect(ids[info.ID]).To(BeTrue())
+					Expect(info.Size).To(Equal(int64(10)))
+					offset += info.Offset
+				}
+				Expect(offset).To(Equal(int64(5)))
+
+				// users that may not share the folder don't see its uploads
+				env.Permissions.ExpectedCalls = nil
+				env.Permissions.On("HasPermission", mock.Anything, mock.Anything, mock.Anything).Return(false, nil)
+				other := ruser.ContextSetUser(context.Background(), &userpb.User{Id: &userpb.UserId{Idp: "idp", OpaqueId: "other"}})
+				uploads, err = dfs.ListUploads(other)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(uploads).To(BeEmpty())
+			})
+		})
+
 		Describe("Upload", func() {
 			var (
 				fileContent = []byte("0123456789")
 			)
 
+			Context("with a separate upload directory", func() {
+				BeforeEach(func() {
+					uploadDir, err := helpers.TempDir("reva-unit-tests-*-uploads")
+					Expect(err).ToNot(HaveOccurred())
+					o.UploadDirectory = uploadDir
+				})
+
+				AfterEach(func() {
+					os.RemoveAll(o.UploadDirectory)
+				})
+
+				It("moves the finished upload into the node tree", func() {
+					bs.On("Upload", mock.AnythingOfType("string"), mock.AnythingOfType("*os.File")).Return(nil)
+
+					err := fs.Upload(ctx, ref, ioutil.NopCloser(bytes.NewReader(fileContent)))
+					Expect(err).ToNot(HaveOccurred())
+
+					n, err := lookup.NodeFromPath(ctx, "/foo")
+					Expect(err).ToNot(HaveOccurred())
+					Expect(n.Exists).To(BeTrue())
+					Expect(n.Blobsize).To(Equal(int64(len(fileContent))))
+
+					leftovers, err := ioutil.ReadDir(o.UploadDirectory)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(leftovers).To(BeEmpty())
+				})
+			})
+
 			It("stores the blob in the blobstore", func() {
 				bs.On("Upload", mock.AnythingOfType("string"), mock.AnythingOfType("*os.File")).
 					Return(nil).
@@ -146,6 +220,98 @@ var _ = Describe("File uploads", func() {
 
 				bs.AssertCalled(GinkgoT(), "Upload", mock.Anything, mock.Anything)
 			})
+
+			It("persists the arbitrary metadata sent with the upload", func() {
+				bs.On("Upload", mock.AnythingOfType("string"), mock.AnythingOfType("*os.File")).Return(nil)
+				permissions.On("AssemblePermissions", mock.Anything, mock.Anything).Return(&provider.ResourcePermissions{Stat: true}, nil)
+
+				uploadIds, err := fs.InitiateUpload(ctx, ref, int64(len(fileContent)), map[string]string{
+					"arbitrary_metadata": `{"http://owncloud.org/ns/app":"editor"}`,
+				})
+				Expect(err).ToNot(HaveOccurred())
+
+				uploadRef := &provider.Reference{Spec: &provider.Reference_Path{Path: uploadIds["simple"]}}
+				err = fs.Upload(ctx, uploadRef, ioutil.NopCloser(bytes.NewReader(fileContent)))
+				Expect(err).ToNot(HaveOccurred())
+
+				ri, err := fs.GetMD(ctx, ref, []string{"http://owncloud.org/ns/app"})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(ri.GetArbitraryMetadata().GetMetadata()).To(HaveKeyWithValue("http://owncloud.org/ns/app", "editor"))
+			})
+
+			It("keeps the mtime sent with the upload", func() {
+				bs.On("Upload", mock.AnythingOfType("string"), mock.AnythingOfType("*os.File")).Return(nil)
+				permissions.On("AssemblePermissions", mock.Anything, mock.Anything).Return(&provider.ResourcePermissions{Stat: true}, nil)
+
+				uploadIds, err := fs.InitiateUpload(ctx, ref, int64(len(fileContent)), map[string]string{
+					"mtime": "1234567890.000000123",
+				})
+				Expect(err).ToNot(HaveOccurred())
+
+				uploadRef := &provider.Reference{Spec: &provider.Reference_Path{Path: uploadIds["simple"]}}
+				err = fs.Upload(ctx, uploadRef, ioutil.NopCloser(bytes.NewReader(fileContent)))
+				Expect(err).ToNot(HaveOccurred())
+
+				ri, err := fs.GetMD(ctx, ref, []string{})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(ri.Mtime.GetSeconds()).To(Equal(uint64(1234567890)))
+				Expect(ri.Mtime.GetNanos()).To(Equal(uint32(123)))
+			})
+
+			Context("with ownCloud chunking", func() {
+				upload := func(name string, chunk []byte, totalLength int) error {
+					uploadIds, err := fs.InitiateUpload(ctx, &provider.Reference{
+						Spec: &provider.Reference_Path{Path: name},
+					}, int64(len(chunk)), map[string]string{
+						"total_length": strconv.Itoa(totalLength),
+					})
+					Expect(err).ToNot(HaveOccurred())
+					uploadRef := &provider.Reference{Spec: &provider.Reference_Path{Path: uploadIds["simple"]}}
+					return fs.Upload(ctx, uploadRef, ioutil.NopCloser(bytes.NewReader(chunk)))
+				}
+
+				BeforeEach(func() {
+					bs.On("Upload", mock.AnythingOfType("string"), mock.AnythingOfType("*os.File")).Return(nil)
+				})
+
+				It("assembles the chunks to a file of the total length", func() {
+					err := upload("/foo-chunking-1234-2-0", fileContent[:4], len(fileContent))
+					Expect(err).To(MatchError(ContainSubstring("partial content")))
+					err = upload("/foo-chunking-1234-2-1", fileContent[4:], len(fileContent))
+					Expect(err).ToNot(HaveOccurred())
+
+					n, err := lookup.NodeFromPath(ctx, "/foo")
+					Expect(err).ToNot(HaveOccurred())
+					Expect(n.Exists).To(BeTrue())
+					Expect(n.Blobsize).To(Equal(int64(len(fileContent))))
+				})
+
+				It("rejects chunks that do not add up to the total length", func() {
+					err := upload("/foo-chunking-5678-2-0", fileContent[:4], len(fileContent)+1)
+					Expect(err).To(HaveOccurred())
+					err = upload("/foo-chunking-5678-2-1", fileContent[4:], len(fileContent)+1)
+					Expect(err).To(MatchError(ContainSubstring("announced")))
+
+					n, err := lookup.NodeFromPath(ctx, "/foo")
+					Expect(err).ToNot(HaveOccurred())
+					Expect(n.Exists).To(BeFalse())
+				})
+			})
+
+			It("rejects invalid arbitrary metadata", func() {
+				_, err := fs.InitiateUpload(ctx, ref, int64(len(fileContent)), map[string]string{
+					"arbitrary_metadata": "not json",
+				})
+				Expect(err).To(HaveOccurred())
+			})
 		})
 	})
 })
+
+func keys(ids map[string]bool) []string {
+	l := make([]string, 0, len(ids))
+	for id := range ids {
+		l = append(l, id)
+	}
+	return l
+}
@@ -18,6 +18,12 @@
 
 package xattrs
 
+import (
+	"syscall"
+
+	"github.com/pkg/xattr"
+)
+
 // Declare a list of xattr keys
 // TODO the below comment is currently copied from the owncloud driver, revisit
 // Currently,extended file attributes have four separated
@@ -70,6 +76,19 @@ const (
 	// the quota for the storage space / tree, regardless who accesses it
 	QuotaAttr string = OcisPrefix + "quota"
 
+	// a label given to a revision by the user, only set on revisions
+	RevisionLabelAttr string = OcisPrefix + "revision.label"
+
 	UserAcePrefix  string = "u:"
 	GroupAcePrefix string = "g:"
 )
+
+// IsNoData checks if an error returned when reading an extended attribute means that the attribute is not set
+func IsNoData(err error) bool {
+	if xerr, ok := err.(*xattr.Error); ok {
+		if serr, ok2 := xerr.Err.(syscall.Errno); ok2 {
+			return serr == syscall.ENODATA
+		}
+	}
+	return false
+}